@@ -0,0 +1,261 @@
+// Package activity funds players with bonus points for their activity on the website
+// according to the configurable rules
+package activity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/helper"
+	"github.com/takama/backer/ledger"
+)
+
+var (
+	// ErrInvalidEvent appears if the event has no ID or player
+	ErrInvalidEvent = errors.New("Activity event should have ID and player")
+	// ErrEventTime appears if the event is dated in the future or older than MaxEventAge
+	ErrEventTime = errors.New("Activity event should not be dated in the future or be too old")
+)
+
+// MaxEventAge defines how old the event could be when it is processed
+var MaxEventAge = time.Hour
+
+// Kinds of the activity events
+const (
+	// LoginStreak is reported when the player logs in, the value is the number of days in a row
+	LoginStreak = "login_streak"
+	// GamesPlayed is reported when the player finishes games, the value is the number of games
+	GamesPlayed = "games_played"
+	// Deposit is reported when the player makes a deposit, the value is the deposit amount
+	Deposit = "deposit"
+)
+
+// Event describes the activity of the player, the ID identifies the event
+// and the same event funds the player once within datastore.IdempotencyRetention,
+// the time of the event is only validated, the points are funded and capped at the server time
+type Event struct {
+	ID      string
+	Player  string
+	Kind    string
+	Value   float64
+	Created time.Time
+}
+
+// Rule defines how much the player is funded for the event of the kind,
+// the player gets the fixed amount and the rate per unit of the event value in the currency,
+// the daily cap limits the points funded by the rule to the player within a day (zero cap is not applied),
+// the rule is applied to eligible events only if the eligibility is defined
+type Rule struct {
+	Name     string
+	Kind     string
+	Amount   backer.Points
+	Rate     backer.Points
+	Currency string
+	DailyCap backer.Points
+	Eligible func(event Event) bool
+}
+
+// Award describes the points funded to the player by the rule,
+// capped reports whether the amount was reduced by the daily cap
+// and replayed reports whether the award was made by the same event before
+type Award struct {
+	Rule     string
+	Amount   backer.Points
+	Currency string
+	Capped   bool
+	Replayed bool
+}
+
+// Engine evaluates the rules against the activity events and funds the players
+type Engine struct {
+	datastore.Controller
+	Rules []Rule
+}
+
+// NewEngine returns new Engine with the rules
+func NewEngine(ctrl datastore.Controller, rules ...Rule) *Engine {
+	return &Engine{Controller: ctrl, Rules: rules}
+}
+
+// Process evaluates the rules against the event and funds the player with the awards
+func (engine *Engine) Process(event Event) ([]Award, error) {
+	return engine.ProcessContext(context.Background(), event)
+}
+
+// ProcessContext evaluates the rules against the event and funds the player using the context
+func (engine *Engine) ProcessContext(ctx context.Context, event Event) ([]Award, error) {
+	return engine.evaluate(ctx, event, false)
+}
+
+// DryRun evaluates the rules against the event and returns the awards without funding the player
+func (engine *Engine) DryRun(event Event) ([]Award, error) {
+	return engine.DryRunContext(context.Background(), event)
+}
+
+// DryRunContext evaluates the rules against the event without funding the player using the context
+func (engine *Engine) DryRunContext(ctx context.Context, event Event) ([]Award, error) {
+	return engine.evaluate(ctx, event, true)
+}
+
+// evaluate applies the rules to the event within one transaction, the player is locked
+// so concurrent events could not exceed the daily caps
+func (engine *Engine) evaluate(ctx context.Context, event Event, dryRun bool) ([]Award, error) {
+	if event.ID == "" || event.Player == "" {
+		return nil, ErrInvalidEvent
+	}
+	now := time.Now()
+	if event.Created.IsZero() {
+		event.Created = now
+	}
+	if event.Created.After(now) || now.Sub(event.Created) > MaxEventAge {
+		return nil, ErrEventTime
+	}
+	var awards []Award
+	err := datastore.WithTransactionContext(ctx, engine.Controller, func(tx datastore.Transact) error {
+		awards = make([]Award, 0)
+		find := engine.Controller.FindPlayerForUpdate
+		if dryRun {
+			find = engine.Controller.FindPlayer
+		}
+		if _, err := find(event.Player, tx); err != nil {
+			return err
+		}
+		for _, rule := range engine.Rules {
+			if rule.Kind != event.Kind || rule.Eligible != nil && !rule.Eligible(event) {
+				continue
+			}
+			award, err := engine.apply(ctx, tx, event, rule, dryRun)
+			if err != nil {
+				return err
+			}
+			if award.Amount > 0 {
+				awards = append(awards, *award)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return awards, nil
+}
+
+// apply funds the player with the award of the rule once per event,
+// the award which was already made by the same event is replayed
+func (engine *Engine) apply(ctx context.Context, tx datastore.Transact,
+	event Event, rule Rule, dryRun bool) (*Award, error) {
+	key := "activity:" + event.ID + ":" + rule.Name
+	if dryRun {
+		record, err := engine.Controller.FindIdempotency(key, tx)
+		if err == nil {
+			return engine.replay(tx, record.Outcome, rule)
+		}
+		if err != datastore.ErrRecordNotFound {
+			return nil, err
+		}
+		return engine.award(tx, event, rule)
+	}
+	var award *Award
+	request := fmt.Sprintf("activity:%s:%s:%v", event.Player, event.Kind, event.Value)
+	outcome, replayed, err := datastore.Once(datastore.WithIdempotencyKey(ctx, key), engine.Controller, tx,
+		request, func() (string, error) {
+			var err error
+			award, err = engine.award(tx, event, rule)
+			if err != nil || award.Amount <= 0 {
+				return "", err
+			}
+			operation := ledger.New(ledger.Fund, ledger.House, ledger.Player(event.Player), award.Amount)
+			operation.Currency = award.Currency
+			operation.Memo = memo(rule)
+			err = ledger.Post(engine.Controller, tx, operation)
+			return operation.ID, err
+		})
+	if err != nil || !replayed {
+		return award, err
+	}
+	return engine.replay(tx, outcome, rule)
+}
+
+// replay returns the award which was already made by the operation,
+// the empty operation means that nothing was funded
+func (engine *Engine) replay(tx datastore.Transact, ID string, rule Rule) (*Award, error) {
+	award := &Award{Rule: rule.Name, Replayed: true}
+	if ID == "" {
+		return award, nil
+	}
+	operation, err := engine.Controller.FindOperation(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	award.Currency = operation.Currency
+	for _, posting := range operation.Postings {
+		if posting.Amount > 0 {
+			award.Amount = posting.Amount
+		}
+	}
+	return award, nil
+}
+
+// award returns the points of the rule for the event reduced by the daily cap
+func (engine *Engine) award(tx datastore.Transact, event Event, rule Rule) (*Award, error) {
+	award := &Award{
+		Rule:     rule.Name,
+		Amount:   backer.Points(helper.TruncatePrice(float32(rule.Amount + rule.Rate*backer.Points(event.Value)))),
+		Currency: rule.Currency,
+	}
+	if rule.DailyCap <= 0 {
+		return award, nil
+	}
+	funded, err := engine.funded(tx, event.Player, rule)
+	if err != nil {
+		return nil, err
+	}
+	if funded+award.Amount > rule.DailyCap {
+		award.Amount = backer.Points(helper.RoundPrice(float32(rule.DailyCap - funded)))
+		award.Capped = true
+	}
+	return award, nil
+}
+
+// funded returns the points funded by the rule to the player within the current day
+func (engine *Engine) funded(tx datastore.Transact, ID string, rule Rule) (backer.Points, error) {
+	var funded backer.Points
+	account := ledger.Player(ID)
+	now := time.Now()
+	year, month, day := now.Date()
+	query := datastore.OperationQuery{
+		Account: account,
+		Kind:    ledger.Fund,
+		From:    time.Date(year, month, day, 0, 0, 0, 0, now.Location()),
+		To:      time.Date(year, month, day+1, 0, 0, 0, 0, now.Location()),
+	}
+	for {
+		operations, next, err := engine.Controller.ListOperations(query, tx)
+		if err != nil {
+			return 0, err
+		}
+		for _, operation := range operations {
+			if operation.Memo != memo(rule) || operation.Currency != rule.Currency {
+				continue
+			}
+			for _, posting := range operation.Postings {
+				if posting.Account == account {
+					funded += posting.Amount
+				}
+			}
+		}
+		if next == "" {
+			return funded, nil
+		}
+		query.Cursor = next
+	}
+}
+
+// memo returns the memo of the operations funded by the rule
+func memo(rule Rule) string {
+	return "activity:" + rule.Name
+}
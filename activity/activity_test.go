@@ -0,0 +1,118 @@
+package activity
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/player"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func TestEngine(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := player.New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	engine := NewEngine(store,
+		Rule{Name: "streak", Kind: LoginStreak, Amount: 10, Eligible: func(event Event) bool {
+			return event.Value >= 3
+		}},
+		Rule{Name: "games", Kind: GamesPlayed, Rate: 5, DailyCap: 40},
+		Rule{Name: "deposit", Kind: Deposit, Rate: 0.1, Currency: "loyalty"},
+	)
+
+	_, err = engine.Process(Event{Player: "p1", Kind: LoginStreak})
+	test(t, err == ErrInvalidEvent, "Expected", ErrInvalidEvent, "got", err)
+	_, err = engine.Process(Event{ID: "e0", Player: "p2", Kind: LoginStreak})
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+	awards, err := engine.Process(Event{ID: "e1", Player: "p1", Kind: LoginStreak, Value: 2})
+	test(t, err == nil, "Expected processing of the event, got", err)
+	test(t, len(awards) == 0, "Expected no awards for not eligible event, got", awards)
+	awards, err = engine.Process(Event{ID: "e2", Player: "p1", Kind: LoginStreak, Value: 3})
+	test(t, err == nil, "Expected processing of the event, got", err)
+	test(t, len(awards) == 1 && awards[0].Amount == 10, "Expected award of 10 points, got", awards)
+
+	awards, err = engine.DryRun(Event{ID: "e3", Player: "p1", Kind: GamesPlayed, Value: 6})
+	test(t, err == nil, "Expected dry run of the event, got", err)
+	test(t, len(awards) == 1 && awards[0].Amount == 30 && !awards[0].Capped, "Expected award of 30 points, got", awards)
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 10, "Expected 10 points after the dry run, got", balance)
+
+	for idx := 0; idx < 2; idx++ {
+		awards, err = engine.Process(Event{ID: "e3", Player: "p1", Kind: GamesPlayed, Value: 6})
+		test(t, err == nil, "Expected processing of the event, got", err)
+		test(t, len(awards) == 1 && awards[0].Amount == 30 && awards[0].Replayed == (idx == 1),
+			"Expected award of 30 points, got", awards)
+	}
+	awards, err = engine.Process(Event{ID: "e4", Player: "p1", Kind: GamesPlayed, Value: 6})
+	test(t, err == nil, "Expected processing of the event, got", err)
+	test(t, len(awards) == 1 && awards[0].Amount == 10 && awards[0].Capped, "Expected capped award of 10 points, got", awards)
+	awards, err = engine.Process(Event{ID: "e5", Player: "p1", Kind: GamesPlayed, Value: 1})
+	test(t, err == nil, "Expected processing of the event, got", err)
+	test(t, len(awards) == 0, "Expected no awards above the daily cap, got", awards)
+	_, err = engine.Process(Event{ID: "e6", Player: "p1", Kind: GamesPlayed, Value: 1,
+		Created: time.Now().Add(48 * time.Hour)})
+	test(t, err == ErrEventTime, "Expected", ErrEventTime, "for the future event, got", err)
+	_, err = engine.Process(Event{ID: "e6", Player: "p1", Kind: GamesPlayed, Value: 1,
+		Created: time.Now().Add(-MaxEventAge - time.Minute)})
+	test(t, err == ErrEventTime, "Expected", ErrEventTime, "for the stale event, got", err)
+
+	awards, err = engine.Process(Event{ID: "e7", Player: "p1", Kind: Deposit, Value: 500})
+	test(t, err == nil, "Expected processing of the event, got", err)
+	test(t, len(awards) == 1 && awards[0].Amount == 50 && awards[0].Currency == "loyalty",
+		"Expected award of 50 loyalty points, got", awards)
+	balance, err = entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 50, "Expected 50 points for the player, got", balance)
+	loyalty, err := entry.Wallet("loyalty")
+	test(t, err == nil, "Expected check wallet of the player, got", err)
+	test(t, loyalty == 50, "Expected 50 loyalty points for the player, got", loyalty)
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation of the ledger, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+}
+
+func TestEngineConcurrentCap(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := player.New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	engine := NewEngine(store, Rule{Name: "games", Kind: GamesPlayed, Rate: 5, DailyCap: 40})
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var funded, rejected int
+	for idx := 0; idx < 10; idx++ {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+			awards, err := engine.Process(Event{ID: fmt.Sprintf("e%d", idx), Player: "p1", Kind: GamesPlayed, Value: 2})
+			test(t, err == nil, "Expected processing of the event, got", err)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if len(awards) == 0 {
+				rejected++
+				return
+			}
+			test(t, awards[0].Amount == 10 && !awards[0].Capped, "Expected award of 10 points, got", awards)
+			funded++
+		}(idx)
+	}
+	wg.Wait()
+	test(t, funded == 4 && rejected == 6, "Expected 4 funded and 6 rejected events, got", funded, rejected)
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 40, "Expected 40 points within the daily cap, got", balance)
+}
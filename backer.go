@@ -1,21 +1,33 @@
 package backer
 
+import (
+	"context"
+)
+
 // Points can traded to goods and represent value like real money
 type Points float32
 
-// Player declares players methods
+// Player declares players methods,
+// the Context methods are bound to the context which could cancel the operation
 type Player interface {
 	ID() string
 	Fund(amount Points) error
+	FundContext(ctx context.Context, amount Points) error
 	Take(amount Points) error
+	TakeContext(ctx context.Context, amount Points) error
 	Balance() (Points, error)
+	BalanceContext(ctx context.Context) (Points, error)
 }
 
-// Tournament declares tournament methods
+// Tournament declares tournament methods,
+// the Context methods are bound to the context which could cancel the operation
 type Tournament interface {
 	Announce(deposit Points) error
+	AnnounceContext(ctx context.Context, deposit Points) error
 	Join(players ...Player) error
+	JoinContext(ctx context.Context, players ...Player) error
 	Result(winners map[Player]Points) error
+	ResultContext(ctx context.Context, winners map[Player]Points) error
 }
 
 // Service defines methods for service control
@@ -1,16 +1,101 @@
 package datastore
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/takama/backer/model"
 )
 
-// Controller defines DB interface for Player and Tournament Entry
+var (
+	// ErrDeadlock appears if transactions are waiting for the locks of each other,
+	// one of them is interrupted and should be repeated
+	ErrDeadlock = errors.New("Deadlock detected")
+)
+
+// MaxRetries defines how many times an operation is repeated on version conflicts
+var MaxRetries = 3
+
+// Controller defines DB interface for Player and Tournament Entry and ledger operations,
+// operations could not be changed once they are created,
+// idempotency records are hidden and replaced after the IdempotencyRetention window,
+// archived records are hidden from Find and List methods until they are restored,
+// scores of the boards are listed by values from the highest one and are not versioned,
+// changes of the same member should be serialized by the caller, e.g. with the player lock,
+// the board is removed with all its scores when it expires.
+// Audit entries, statistics and lots of the players are kept apart from the player records,
+// so they do not widen version conflicts of the players, they are not versioned
+// and their changes should be serialized by the caller with the player lock,
+// audit entries could not be changed once they are created and are kept when the player is deleted.
+// A transaction which is started with context binds all methods called within it
+// to the context, the transaction is rolled back as soon as the context is done
 type Controller interface {
 	Transaction() (Transact, error)
+	TransactionContext(ctx context.Context) (Transact, error)
 	NewPlayer(ID string, tx Transact) error
 	FindPlayer(ID string, tx Transact) (*model.Player, error)
+	FindPlayerForUpdate(ID string, tx Transact) (*model.Player, error)
 	SavePlayer(player *model.Player, tx Transact) error
+	ListPlayers(query PlayerQuery, tx Transact) ([]model.Player, string, error)
+	DeletePlayer(ID string, tx Transact) error
+	ArchivePlayer(ID string, tx Transact) error
+	RestorePlayer(ID string, tx Transact) error
+	NewAuditEntry(player string, entry *model.AuditEntry, tx Transact) error
+	ListAudit(player string, tx Transact) ([]model.AuditEntry, error)
+	FindStats(player string, tx Transact) (*model.Stats, error)
+	SaveStats(player string, stats *model.Stats, tx Transact) error
+	FindLots(player string, tx Transact) ([]model.Lot, error)
+	SaveLots(player string, lots []model.Lot, tx Transact) error
 	NewTournament(ID uint64, tx Transact) error
 	FindTournament(ID uint64, tx Transact) (*model.Tournament, error)
+	FindTournamentForUpdate(ID uint64, tx Transact) (*model.Tournament, error)
 	SaveTournament(tournament *model.Tournament, tx Transact) error
+	ListTournaments(query TournamentQuery, tx Transact) ([]model.Tournament, string, error)
+	DeleteTournament(ID uint64, tx Transact) error
+	ArchiveTournament(ID uint64, tx Transact) error
+	RestoreTournament(ID uint64, tx Transact) error
+	NewOperation(operation *model.Operation, tx Transact) error
+	FindOperation(ID string, tx Transact) (*model.Operation, error)
+	ListOperations(query OperationQuery, tx Transact) ([]model.Operation, string, error)
+	NewIdempotency(record *model.Idempotency, tx Transact) error
+	FindIdempotency(key string, tx Transact) (*model.Idempotency, error)
+	SaveScore(board, member string, value float64, tx Transact) error
+	FindScore(board, member string, tx Transact) (*model.Score, error)
+	FindScoreValue(board, member string, tx Transact) (float64, error)
+	ListScores(board string, count int, tx Transact) ([]model.Score, error)
+	DeleteScore(board, member string, tx Transact) error
+	ExpireBoard(board string, at time.Time, tx Transact) error
+}
+
+// ConflictError appears if a record was changed since it was read,
+// the saved version does not match the stored version of the record,
+// an empty ID means that some of the records read by Record were changed
+type ConflictError struct {
+	Record  string
+	ID      string
+	Version uint64
+	Stored  uint64
+}
+
+// Error implements error interface
+func (e *ConflictError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("Records of %s were changed concurrently", e.Record)
+	}
+	return fmt.Sprintf("Record %s %s version %d conflicts with stored version %d",
+		e.Record, e.ID, e.Version, e.Stored)
+}
+
+// IsConflict reports whether an error is a version conflict
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// IsRetryable reports whether a transaction failed with an error
+// which could disappear if the transaction is repeated
+func IsRetryable(err error) bool {
+	return IsConflict(err) || err == ErrDeadlock
 }
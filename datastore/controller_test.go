@@ -0,0 +1,641 @@
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/model"
+)
+
+// backend combines store and controller methods for conformance tests of every backend
+type backend interface {
+	Store
+	Controller
+}
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func testRollback(t *testing.T, store backend) {
+
+	store.Reset()
+	err := store.NewPlayer("p1", nil)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = store.NewTournament(1, nil)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+
+	tx, err := store.Transaction()
+	test(t, err == nil, "Expected transaction, got", err)
+	err = store.NewPlayer("p2", tx)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	player, err := store.FindPlayerForUpdate("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx)
+	test(t, err == nil, "Expected save the player, got", err)
+	tournament, err := store.FindTournamentForUpdate(1, tx)
+	test(t, err == nil, "Expected find the tournament, got", err)
+	tournament.Deposit = 1000
+	err = store.SaveTournament(tournament, tx)
+	test(t, err == nil, "Expected save the tournament, got", err)
+	err = tx.Rollback()
+	test(t, err == nil, "Expected rollback, got", err)
+
+	_, err = store.FindPlayer("p2", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	player, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 0, "Expected 0 points for the player, got", player.Balance)
+	test(t, player.Version == 0, "Expected version 0 of the player, got", player.Version)
+	tournament, err = store.FindTournament(1, nil)
+	test(t, err == nil, "Expected find the tournament, got", err)
+	test(t, tournament.Deposit == 0, "Expected 0 deposit of the tournament, got", tournament.Deposit)
+}
+
+func testListPlayers(t *testing.T, store backend) {
+
+	store.Reset()
+	for idx, id := range []string{"p1", "p2", "p3", "b1", "b2"} {
+		store.NewPlayer(id, nil)
+		player, _ := store.FindPlayer(id, nil)
+		player.Balance = backer.Points(100 * idx)
+		store.SavePlayer(player, nil)
+	}
+
+	players, next, err := store.ListPlayers(PlayerQuery{Prefix: "p", Page: Page{Limit: 2}}, nil)
+	test(t, err == nil, "Expected list of players, got", err)
+	test(t, len(players) == 2, "Expected 2 players, got", len(players))
+	test(t, players[0].ID == "p1" && players[1].ID == "p2", "Expected p1, p2 players, got", players)
+	test(t, next != "", "Expected next page cursor, got empty")
+	players, next, err = store.ListPlayers(PlayerQuery{Prefix: "p", Page: Page{Cursor: next, Limit: 2}}, nil)
+	test(t, err == nil, "Expected list of players, got", err)
+	test(t, len(players) == 1 && players[0].ID == "p3", "Expected p3 player, got", players)
+	test(t, next == "", "Expected no more pages, got", next)
+
+	min, max := backer.Points(100), backer.Points(300)
+	players, _, err = store.ListPlayers(PlayerQuery{
+		MinBalance: &min, MaxBalance: &max, SortBy: PlayersByBalance, Page: Page{Order: Descending},
+	}, nil)
+	test(t, err == nil, "Expected list of players, got", err)
+	test(t, len(players) == 3, "Expected 3 players, got", len(players))
+	test(t, players[0].ID == "b1" && players[2].ID == "p2", "Expected b1, p3, p2 players, got", players)
+
+	_, _, err = store.ListPlayers(PlayerQuery{Page: Page{Cursor: "?"}}, nil)
+	test(t, err == ErrInvalidCursor, "Expected", ErrInvalidCursor, "got", err)
+}
+
+func testListTournaments(t *testing.T, store backend) {
+
+	store.Reset()
+	start := time.Now()
+	for id := uint64(1); id <= 5; id++ {
+		store.NewTournament(id, nil)
+		tournament, _ := store.FindTournament(id, nil)
+		tournament.Created = start.Add(time.Duration(id) * time.Hour)
+		tournament.IsFinished = id%2 == 0
+		tournament.Bidders = append(tournament.Bidders, model.Bidder{ID: "p1", Backers: []string{"b1"}})
+		if id > 3 {
+			tournament.Bidders[0].Backers = nil
+		}
+		store.SaveTournament(tournament, nil)
+	}
+
+	tournaments, next, err := store.ListTournaments(TournamentQuery{State: Open}, nil)
+	test(t, err == nil, "Expected list of tournaments, got", err)
+	test(t, len(tournaments) == 3, "Expected 3 tournaments, got", len(tournaments))
+	test(t, next == "", "Expected no more pages, got", next)
+
+	tournaments, _, err = store.ListTournaments(TournamentQuery{
+		From: start.Add(2 * time.Hour), To: start.Add(5 * time.Hour),
+		SortBy: TournamentsByDate, Page: Page{Order: Descending},
+	}, nil)
+	test(t, err == nil, "Expected list of tournaments, got", err)
+	test(t, len(tournaments) == 3, "Expected 3 tournaments, got", len(tournaments))
+	test(t, tournaments[0].ID == 4 && tournaments[2].ID == 2, "Expected 4, 3, 2 tournaments, got", tournaments)
+
+	var ids []uint64
+	query := TournamentQuery{Participant: "b1", Page: Page{Limit: 1}}
+	for {
+		tournaments, next, err = store.ListTournaments(query, nil)
+		test(t, err == nil, "Expected list of tournaments, got", err)
+		for _, tournament := range tournaments {
+			ids = append(ids, tournament.ID)
+		}
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	test(t, len(ids) == 3 && ids[0] == 1 && ids[2] == 3, "Expected 1, 2, 3 tournaments, got", ids)
+}
+
+func testArchive(t *testing.T, store backend) {
+
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewTournament(1, nil)
+	err := store.ArchivePlayer("p1", nil)
+	test(t, err == nil, "Expected archive the player, got", err)
+	err = store.ArchivePlayer("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	err = store.NewPlayer("p1", nil)
+	test(t, err == ErrAlreadyExist, "Expected", ErrAlreadyExist, "got", err)
+	err = store.RestorePlayer("p1", nil)
+	test(t, err == nil, "Expected restore the player, got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+
+	err = store.ArchiveTournament(1, nil)
+	test(t, err == nil, "Expected archive the tournament, got", err)
+	tournaments, _, err := store.ListTournaments(TournamentQuery{}, nil)
+	test(t, err == nil, "Expected list of tournaments, got", err)
+	test(t, len(tournaments) == 0, "Expected no tournaments, got", tournaments)
+	tournaments, _, err = store.ListTournaments(TournamentQuery{Archived: true}, nil)
+	test(t, err == nil, "Expected list of tournaments, got", err)
+	test(t, len(tournaments) == 1, "Expected archived tournament, got", tournaments)
+	err = store.RestoreTournament(1, nil)
+	test(t, err == nil, "Expected restore the tournament, got", err)
+
+	tx, _ := store.Transaction()
+	err = store.DeletePlayer("p1", tx)
+	test(t, err == nil, "Expected delete the player, got", err)
+	err = store.DeleteTournament(1, tx)
+	test(t, err == nil, "Expected delete the tournament, got", err)
+	err = tx.Rollback()
+	test(t, err == nil, "Expected rollback, got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	tx, _ = store.Transaction()
+	err = store.DeletePlayer("p1", tx)
+	test(t, err == nil, "Expected delete the player, got", err)
+	err = store.DeleteTournament(1, tx)
+	test(t, err == nil, "Expected delete the tournament, got", err)
+	err = tx.Commit()
+	test(t, err == nil, "Expected commit, got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = store.FindTournament(1, nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	err = store.DeletePlayer("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "for missing player, got", err)
+	err = store.DeleteTournament(1, nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "for missing tournament, got", err)
+}
+
+func testCancel(t *testing.T, store backend) {
+
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tx, err := store.TransactionContext(ctx)
+	test(t, err == nil, "Expected transaction, got", err)
+	player, err := store.FindPlayerForUpdate("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx)
+	test(t, err == nil, "Expected save the player, got", err)
+	cancel()
+	err = store.NewPlayer("p2", tx)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	err = tx.Commit()
+	test(t, err != nil, "Expected commit error, got nil")
+	player, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 0, "Expected 0 points for the player, got", player.Balance)
+	_, err = store.FindPlayer("p2", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = store.TransactionContext(ctx)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+
+	tx, _ = store.Transaction()
+	_, err = store.FindPlayerForUpdate("p1", tx)
+	test(t, err == nil, "Expected lock the player, got", err)
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	waiting, _ := store.TransactionContext(ctx)
+	_, err = store.FindPlayerForUpdate("p1", waiting)
+	test(t, err == context.DeadlineExceeded, "Expected", context.DeadlineExceeded, "got", err)
+	tx.Commit()
+}
+
+func testWithTransaction(t *testing.T, store backend) {
+
+	err := store.Reset()
+	test(t, err == nil, "Expected reset of the store, got", err)
+	err = WithTransaction(store, func(tx Transact) error {
+		return store.NewPlayer("p1", tx)
+	})
+	test(t, err == nil, "Expected committed unit of work, got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find committed player, got", err)
+
+	err = WithTransaction(store, func(tx Transact) error {
+		if err := store.NewPlayer("p2", tx); err != nil {
+			return err
+		}
+		return ErrRecordNotFound
+	})
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = store.FindPlayer("p2", nil)
+	test(t, err == ErrRecordNotFound, "Expected rolled back player, got", err)
+
+	func() {
+		defer func() {
+			test(t, recover() == "panic", "Expected re-panic of the unit of work")
+		}()
+		WithTransaction(store, func(tx Transact) error {
+			store.NewPlayer("p3", tx)
+			panic("panic")
+		})
+	}()
+	_, err = store.FindPlayer("p3", nil)
+	test(t, err == ErrRecordNotFound, "Expected rolled back player, got", err)
+
+	attempts := 0
+	err = WithTransaction(store, func(tx Transact) error {
+		attempts++
+		player, err := store.FindPlayer("p1", tx)
+		if err != nil {
+			return err
+		}
+		if attempts < MaxRetries {
+			player.Version++
+		}
+		return store.SavePlayer(player, tx)
+	})
+	test(t, err == nil, "Expected retried unit of work, got", err)
+	test(t, attempts == MaxRetries, "Expected", MaxRetries, "attempts, got", attempts)
+
+	attempts = 0
+	err = WithTransaction(store, func(tx Transact) error {
+		attempts++
+		return ErrDeadlock
+	})
+	test(t, err == ErrDeadlock, "Expected", ErrDeadlock, "got", err)
+	test(t, attempts == MaxRetries+1, "Expected", MaxRetries+1, "attempts, got", attempts)
+}
+
+func testSavepoints(t *testing.T, store backend) {
+
+	err := store.Reset()
+	test(t, err == nil, "Expected reset of the store, got", err)
+	tx, err := store.Transaction()
+	test(t, err == nil, "Expected transaction, got", err)
+	err = store.NewPlayer("p1", tx)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = tx.Savepoint("a")
+	test(t, err == nil, "Expected savepoint, got", err)
+	err = store.NewPlayer("p2", tx)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = tx.Savepoint("b")
+	test(t, err == nil, "Expected savepoint, got", err)
+	player, err := store.FindPlayer("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx)
+	test(t, err == nil, "Expected save the player, got", err)
+	err = store.NewPlayer("p3", tx)
+	test(t, err == nil, "Expected creating a new player, got", err)
+
+	err = tx.RollbackTo("b")
+	test(t, err == nil, "Expected rollback to savepoint, got", err)
+	_, err = store.FindPlayer("p3", tx)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	player, err = store.FindPlayer("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 0, "Expected 0 points for the player, got", player.Balance)
+	players, _, err := store.ListPlayers(PlayerQuery{}, tx)
+	test(t, err == nil, "Expected list players, got", err)
+	test(t, len(players) == 2, "Expected 2 players, got", len(players))
+	err = tx.RollbackTo("b")
+	test(t, err == nil, "Expected repeated rollback to savepoint, got", err)
+	err = tx.ReleaseSavepoint("b")
+	test(t, err == nil, "Expected release of savepoint, got", err)
+	err = tx.RollbackTo("b")
+	test(t, err == ErrSavepointNotFound, "Expected", ErrSavepointNotFound, "got", err)
+	err = tx.RollbackTo("a")
+	test(t, err == nil, "Expected rollback to savepoint, got", err)
+	_, err = store.FindPlayer("p2", tx)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+
+	err = WithSavepoint(tx, "c", func() error {
+		if err := store.NewPlayer("p4", tx); err != nil {
+			return err
+		}
+		return ErrDeadlock
+	})
+	test(t, err == ErrDeadlock, "Expected", ErrDeadlock, "got", err)
+	err = WithSavepoint(tx, "c", func() error {
+		return store.NewPlayer("p5", tx)
+	})
+	test(t, err == nil, "Expected savepoint changes, got", err)
+	err = tx.ReleaseSavepoint("c")
+	test(t, err == ErrSavepointNotFound, "Expected", ErrSavepointNotFound, "got", err)
+	err = tx.Commit()
+	test(t, err == nil, "Expected commit of the transaction, got", err)
+
+	players, _, err = store.ListPlayers(PlayerQuery{}, nil)
+	test(t, err == nil, "Expected list players, got", err)
+	test(t, len(players) == 2 && players[0].ID == "p1" && players[1].ID == "p5",
+		"Expected players p1 and p5, got", players)
+}
+
+func testOperations(t *testing.T, store backend) {
+
+	store.Reset()
+	start := time.Now()
+	for idx, id := range []string{"o1", "o2", "o3", "o4"} {
+		operation := &model.Operation{
+			ID:      id,
+			Kind:    "fund",
+			Created: start.Add(time.Duration(idx) * time.Hour),
+			Postings: []model.Posting{
+				{Account: "house", Amount: -100},
+				{Account: "player:p1", Amount: 100},
+			},
+		}
+		if idx%2 == 1 {
+			operation.Kind = "contribution"
+			operation.Tournament = 1
+			operation.Postings = []model.Posting{
+				{Account: "player:p1", Amount: -50},
+				{Account: "escrow:1", Amount: 50},
+			}
+		}
+		err := store.NewOperation(operation, nil)
+		test(t, err == nil, "Expected creating a new operation, got", err)
+	}
+	err := store.NewOperation(&model.Operation{ID: "o1"}, nil)
+	test(t, err == ErrAlreadyExist, "Expected", ErrAlreadyExist, "got", err)
+
+	operation, err := store.FindOperation("o2", nil)
+	test(t, err == nil, "Expected find the operation, got", err)
+	test(t, operation.Kind == "contribution" && len(operation.Postings) == 2 &&
+		operation.Postings[1].Account == "escrow:1" && operation.Postings[1].Amount == 50,
+		"Expected contribution of the operation, got", operation)
+	_, err = store.FindOperation("o5", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+
+	operations, _, err := store.ListOperations(OperationQuery{Account: "escrow:1"}, nil)
+	test(t, err == nil, "Expected list of operations, got", err)
+	test(t, len(operations) == 2 && operations[0].ID == "o2" && operations[1].ID == "o4",
+		"Expected o2 and o4 operations, got", operations)
+	operations, _, err = store.ListOperations(OperationQuery{Kind: "fund", Tournament: 1}, nil)
+	test(t, err == nil, "Expected list of operations, got", err)
+	test(t, len(operations) == 0, "Expected no operations, got", operations)
+	operations, _, err = store.ListOperations(OperationQuery{
+		Account: "player:p1", From: start.Add(time.Hour), To: start.Add(3 * time.Hour),
+		Page: Page{Order: Descending},
+	}, nil)
+	test(t, err == nil, "Expected list of operations, got", err)
+	test(t, len(operations) == 2 && operations[0].ID == "o3" && operations[1].ID == "o2",
+		"Expected o3 and o2 operations, got", operations)
+
+	var ids []string
+	query := OperationQuery{Page: Page{Limit: 3}}
+	for {
+		operations, next, err := store.ListOperations(query, nil)
+		test(t, err == nil, "Expected list of operations, got", err)
+		for _, operation := range operations {
+			ids = append(ids, operation.ID)
+		}
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	test(t, len(ids) == 4 && ids[0] == "o1" && ids[3] == "o4", "Expected o1 - o4 operations, got", ids)
+
+	tx, err := store.Transaction()
+	test(t, err == nil, "Expected transaction, got", err)
+	err = store.NewOperation(&model.Operation{ID: "o5", Created: start,
+		Postings: []model.Posting{{Account: "escrow:1"}}}, tx)
+	test(t, err == nil, "Expected creating a new operation, got", err)
+	operations, _, err = store.ListOperations(OperationQuery{Account: "escrow:1"}, tx)
+	test(t, err == nil, "Expected list of operations, got", err)
+	test(t, len(operations) == 3, "Expected 3 operations in the transaction, got", len(operations))
+	err = tx.Rollback()
+	test(t, err == nil, "Expected rollback of the transaction, got", err)
+	_, err = store.FindOperation("o5", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+}
+
+func testIdempotency(t *testing.T, store backend) {
+
+	store.Reset()
+	ctx := WithIdempotencyKey(context.Background(), "k1")
+	test(t, IdempotencyKey(ctx) == "k1", "Expected idempotency key k1, got", IdempotencyKey(ctx))
+	calls := 0
+	request := func(tx Transact) (string, bool, error) {
+		return Once(ctx, store, tx, "fund:p1:100", func() (string, error) {
+			calls++
+			return "o1", nil
+		})
+	}
+	for idx := 0; idx < 2; idx++ {
+		var outcome string
+		var replayed bool
+		err := WithTransaction(store, func(tx Transact) (err error) {
+			outcome, replayed, err = request(tx)
+			return err
+		})
+		test(t, err == nil, "Expected idempotent request, got", err)
+		test(t, outcome == "o1", "Expected outcome o1, got", outcome)
+		test(t, replayed == (idx > 0), "Expected replayed", idx > 0, "got", replayed)
+	}
+	test(t, calls == 1, "Expected 1 call of the request, got", calls)
+
+	_, _, err := Once(ctx, store, nil, "fund:p1:200", func() (string, error) {
+		return "o2", nil
+	})
+	test(t, err == ErrIdempotencyKeyReused, "Expected", ErrIdempotencyKeyReused, "got", err)
+	err = store.NewIdempotency(&model.Idempotency{Key: "k1", Created: time.Now()}, nil)
+	test(t, err == ErrAlreadyExist, "Expected", ErrAlreadyExist, "got", err)
+
+	_, _, err = Once(WithIdempotencyKey(context.Background(), "k2"), store, nil, "take:p1:100",
+		func() (string, error) {
+			return "", ErrRecordNotFound
+		})
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = store.FindIdempotency("k2", nil)
+	test(t, err == ErrRecordNotFound, "Expected failed request is not kept, got", err)
+
+	retention := IdempotencyRetention
+	defer func() {
+		IdempotencyRetention = retention
+	}()
+	IdempotencyRetention = 50 * time.Millisecond
+	err = store.NewIdempotency(&model.Idempotency{Key: "k3", Request: "r", Created: time.Now()}, nil)
+	test(t, err == nil, "Expected keeping the outcome, got", err)
+	_, err = store.FindIdempotency("k3", nil)
+	test(t, err == nil, "Expected find the outcome, got", err)
+	time.Sleep(2 * IdempotencyRetention)
+	_, err = store.FindIdempotency("k3", nil)
+	test(t, err == ErrRecordNotFound, "Expected expired outcome, got", err)
+	err = store.NewIdempotency(&model.Idempotency{Key: "k3", Request: "r", Created: time.Now()}, nil)
+	test(t, err == nil, "Expected replace expired outcome, got", err)
+}
+
+func testScores(t *testing.T, store backend) {
+
+	store.Reset()
+	_, err := store.FindScore("b1", "p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	scores, err := store.ListScores("b1", 10, nil)
+	test(t, err == nil && len(scores) == 0, "Expected empty board, got", scores, err)
+
+	for member, value := range map[string]float64{"p1": 10, "p2": 30.5, "p3": 20, "p4": 20} {
+		err = store.SaveScore("b1", member, value, nil)
+		test(t, err == nil, "Expected saving the score, got", err)
+	}
+	err = store.SaveScore("b2", "p1", 100, nil)
+	test(t, err == nil, "Expected saving the score, got", err)
+	scores, err = store.ListScores("b1", 3, nil)
+	test(t, err == nil, "Expected listing scores, got", err)
+	test(t, len(scores) == 3, "Expected 3 scores, got", len(scores))
+	if len(scores) == 3 {
+		test(t, scores[0].Member == "p2" && scores[0].Value == 30.5 && scores[0].Rank == 1,
+			"Expected p2 with 30.5 ranked 1, got", scores[0])
+		test(t, scores[1].Member == "p4" && scores[1].Rank == 2, "Expected p4 ranked 2, got", scores[1])
+		test(t, scores[2].Member == "p3" && scores[2].Rank == 2, "Expected p3 ranked 2, got", scores[2])
+		test(t, scores[0].Board == "b1", "Expected board b1, got", scores[0].Board)
+	}
+	score, err := store.FindScore("b1", "p1", nil)
+	test(t, err == nil, "Expected finding the score, got", err)
+	if err == nil {
+		test(t, score.Value == 10 && score.Rank == 4, "Expected p1 with 10 ranked 4, got", score)
+	}
+	score, err = store.FindScore("b1", "p3", nil)
+	test(t, err == nil && score.Rank == 2, "Expected p3 ranked 2, got", score, err)
+
+	tx, err := store.Transaction()
+	test(t, err == nil, "Expected transaction, got", err)
+	test(t, store.SaveScore("b1", "p1", 40, tx) == nil, "Expected saving the score in the transaction")
+	test(t, store.DeleteScore("b1", "p2", tx) == nil, "Expected deleting the score in the transaction")
+	test(t, tx.Savepoint("s1") == nil, "Expected savepoint")
+	test(t, store.SaveScore("b1", "p5", 50, tx) == nil, "Expected saving the score in the transaction")
+	test(t, tx.RollbackTo("s1") == nil, "Expected rollback to savepoint")
+	score, err = store.FindScore("b1", "p1", tx)
+	test(t, err == nil && score.Value == 40 && score.Rank == 1,
+		"Expected pending p1 with 40 ranked 1, got", score, err)
+	_, err = store.FindScore("b1", "p5", tx)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	scores, err = store.ListScores("b1", -1, tx)
+	test(t, err == nil && len(scores) == 3, "Expected 3 pending scores, got", scores, err)
+	err = tx.Rollback()
+	test(t, err == nil, "Expected rollback of the transaction, got", err)
+	score, err = store.FindScore("b1", "p2", nil)
+	test(t, err == nil && score.Value == 30.5, "Expected restored p2, got", score, err)
+
+	err = WithTransaction(store, func(tx Transact) error {
+		if err := store.SaveScore("b1", "p1", 40, tx); err != nil {
+			return err
+		}
+		return store.DeleteScore("b1", "p2", tx)
+	})
+	test(t, err == nil, "Expected committed scores, got", err)
+	scores, err = store.ListScores("b1", 1, nil)
+	test(t, err == nil && len(scores) == 1 && scores[0].Member == "p1",
+		"Expected p1 on the top, got", scores, err)
+	_, err = store.FindScore("b1", "p2", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	score, err = store.FindScore("b2", "p1", nil)
+	test(t, err == nil && score.Value == 100 && score.Rank == 1, "Expected p1 in b2, got", score, err)
+	value, err := store.FindScoreValue("b2", "p1", nil)
+	test(t, err == nil && value == 100, "Expected value of p1 in b2, got", value, err)
+	_, err = store.FindScoreValue("b2", "p2", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+
+	err = WithTransaction(store, func(tx Transact) error {
+		if err := store.ExpireBoard("b1", time.Now().Add(time.Hour), tx); err != nil {
+			return err
+		}
+		return store.ExpireBoard("b2", time.Now().Add(-time.Second), tx)
+	})
+	test(t, err == nil, "Expected expiry of the boards, got", err)
+	scores, err = store.ListScores("b1", -1, nil)
+	test(t, err == nil && len(scores) == 3, "Expected b1 which is not expired yet, got", scores, err)
+	scores, err = store.ListScores("b2", -1, nil)
+	test(t, err == nil && len(scores) == 0, "Expected expired b2, got", scores, err)
+	_, err = store.FindScoreValue("b2", "p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	err = store.SaveScore("b2", "p2", 10, nil)
+	test(t, err == nil, "Expected saving the score, got", err)
+	scores, err = store.ListScores("b2", -1, nil)
+	test(t, err == nil && len(scores) == 1 && scores[0].Member == "p2",
+		"Expected b2 created again without expired scores, got", scores, err)
+}
+
+func testPlayerRecords(t *testing.T, store backend) {
+
+	store.Reset()
+	test(t, store.NewPlayer("p1", nil) == nil, "Expected creating the player")
+	audit, err := store.ListAudit("p1", nil)
+	test(t, err == nil && len(audit) == 0, "Expected empty audit, got", audit, err)
+	_, err = store.FindStats("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	lots, err := store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 0, "Expected no lots, got", lots, err)
+
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	version := player.Version
+	now := time.Now().UTC().Truncate(time.Second)
+	err = WithTransaction(store, func(tx Transact) error {
+		if _, err := store.FindPlayerForUpdate("p1", tx); err != nil {
+			return err
+		}
+		if err := store.NewAuditEntry("p1", &model.AuditEntry{Action: "status", Actor: "admin", Created: now}, tx); err != nil {
+			return err
+		}
+		if err := store.SaveStats("p1", &model.Stats{Bidder: model.Performance{Entries: 1}}, tx); err != nil {
+			return err
+		}
+		if err := store.SaveLots("p1", []model.Lot{{Operation: "o1", Amount: 10, Expires: now}}, tx); err != nil {
+			return err
+		}
+		audit, err := store.ListAudit("p1", tx)
+		if err != nil || len(audit) != 1 {
+			t.Error("Expected pending audit entry, got", audit, err)
+		}
+		return nil
+	})
+	test(t, err == nil, "Expected records of the player, got", err)
+	player, err = store.FindPlayer("p1", nil)
+	test(t, err == nil && player.Version == version, "Expected the player record is not changed, got", player, err)
+	err = store.NewAuditEntry("p1", &model.AuditEntry{Action: "limits", Actor: "p1", Created: now}, nil)
+	test(t, err == nil, "Expected adding the audit entry, got", err)
+	audit, err = store.ListAudit("p1", nil)
+	test(t, err == nil && len(audit) == 2 && audit[0].Action == "status" && audit[1].Action == "limits" &&
+		audit[0].Created.Equal(now), "Expected audit entries in order, got", audit, err)
+	stats, err := store.FindStats("p1", nil)
+	test(t, err == nil && stats.Bidder.Entries == 1, "Expected stats of the player, got", stats, err)
+	lots, err = store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 1 && lots[0].Amount == 10, "Expected lots of the player, got", lots, err)
+
+	tx, err := store.Transaction()
+	test(t, err == nil, "Expected transaction, got", err)
+	test(t, store.NewAuditEntry("p1", &model.AuditEntry{Action: "credit limit"}, tx) == nil, "Expected audit entry")
+	test(t, store.SaveLots("p1", nil, tx) == nil, "Expected removing lots")
+	test(t, tx.Rollback() == nil, "Expected rollback of the transaction")
+	audit, err = store.ListAudit("p1", nil)
+	test(t, err == nil && len(audit) == 2, "Expected rolled back audit entry, got", audit, err)
+	lots, err = store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 1, "Expected restored lots, got", lots, err)
+
+	test(t, store.DeletePlayer("p1", nil) == nil, "Expected deleting the player")
+	_, err = store.FindStats("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected stats removed with the player, got", err)
+	lots, err = store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 0, "Expected lots removed with the player, got", lots, err)
+	audit, err = store.ListAudit("p1", nil)
+	test(t, err == nil && len(audit) == 2, "Expected audit kept after deleting the player, got", audit, err)
+}
@@ -0,0 +1,73 @@
+package datastore
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrIdempotencyKeyReused appears if the idempotency key was already used for another request
+	ErrIdempotencyKeyReused = errors.New("Idempotency key was already used for another request")
+)
+
+// IdempotencyRetention defines how long outcomes of the requests are kept for replays
+var IdempotencyRetention = 24 * time.Hour
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of the context which carries the idempotency key,
+// mutating operations called with the context are done once per key within the retention window
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKey returns the idempotency key of the context or an empty string
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// Once runs fn within the transaction unless the request was already done with the idempotency key
+// of the context, it reports whether the request is replayed and returns the outcome of the original request.
+// The request describes the operation and its arguments, the key could not be used for another request.
+// Only successful outcomes are kept, failed requests do not change anything and could be repeated with the same key
+func Once(ctx context.Context, ctrl Controller, tx Transact,
+	request string, fn func() (string, error)) (string, bool, error) {
+	key := IdempotencyKey(ctx)
+	if key == "" {
+		outcome, err := fn()
+		return outcome, false, err
+	}
+	record, err := ctrl.FindIdempotency(key, tx)
+	if err == nil {
+		if record.Request != request {
+			return "", false, ErrIdempotencyKeyReused
+		}
+		return record.Outcome, true, nil
+	}
+	if err != ErrRecordNotFound {
+		return "", false, err
+	}
+	outcome, err := fn()
+	if err != nil {
+		return "", false, err
+	}
+	err = ctrl.NewIdempotency(&model.Idempotency{
+		Key:     key,
+		Request: request,
+		Outcome: outcome,
+		Created: time.Now(),
+	}, tx)
+	if err == ErrAlreadyExist {
+		return "", false, &ConflictError{Record: "idempotency", ID: key}
+	}
+	return outcome, false, err
+}
+
+// expired reports whether the outcome of the request is out of the retention window
+func expired(record *model.Idempotency) bool {
+	return time.Since(record.Created) >= IdempotencyRetention
+}
@@ -0,0 +1,282 @@
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrInvalidCursor appears if the page cursor could not be decoded
+	ErrInvalidCursor = errors.New("Invalid page cursor")
+)
+
+// DefaultLimit defines the page size if the limit is not specified
+var DefaultLimit = 100
+
+// Order defines sort direction of the listing
+type Order int
+
+const (
+	// Ascending order from the smallest to the largest values
+	Ascending Order = iota
+	// Descending order from the largest to the smallest values
+	Descending
+)
+
+// Page defines cursor based pagination of the listing,
+// the cursor is returned by the previous listing of the same query
+type Page struct {
+	Cursor string
+	Limit  int
+	Order  Order
+}
+
+// PlayerSort defines sort field of the players listing
+type PlayerSort int
+
+const (
+	// PlayersByID sorts players by ID
+	PlayersByID PlayerSort = iota
+	// PlayersByBalance sorts players by balance
+	PlayersByBalance
+)
+
+// PlayerQuery contains filters of the players listing,
+// nil balance boundaries are not applied, archived players are listed instead of active ones if Archived is set
+type PlayerQuery struct {
+	Prefix     string
+	MinBalance *backer.Points
+	MaxBalance *backer.Points
+	Archived   bool
+	SortBy     PlayerSort
+	Page
+}
+
+// TournamentState defines state filter of the tournaments listing
+type TournamentState int
+
+const (
+	// AnyState matches all tournaments
+	AnyState TournamentState = iota
+	// Open matches tournaments which are not finished
+	Open
+	// Finished matches finished tournaments
+	Finished
+)
+
+// TournamentSort defines sort field of the tournaments listing
+type TournamentSort int
+
+const (
+	// TournamentsByID sorts tournaments by ID
+	TournamentsByID TournamentSort = iota
+	// TournamentsByDate sorts tournaments by creation date
+	TournamentsByDate
+)
+
+// TournamentQuery contains filters of the tournaments listing,
+// zero dates are not applied, the date range includes From and excludes To,
+// archived tournaments are listed instead of active ones if Archived is set
+type TournamentQuery struct {
+	State       TournamentState
+	From        time.Time
+	To          time.Time
+	Participant string
+	Archived    bool
+	SortBy      TournamentSort
+	Page
+}
+
+// OperationQuery contains filters of the operations listing, operations are sorted by creation date,
+// zero dates are not applied, the date range includes From and excludes To,
+// an empty account or kind and zero tournament match all operations
+type OperationQuery struct {
+	Account    string
+	Kind       string
+	Tournament uint64
+	From       time.Time
+	To         time.Time
+	Page
+}
+
+// Match reports whether the player satisfies the query
+func (query PlayerQuery) Match(player *model.Player) bool {
+	if player.Archived != query.Archived {
+		return false
+	}
+	if !strings.HasPrefix(player.ID, query.Prefix) {
+		return false
+	}
+	if query.MinBalance != nil && player.Balance < *query.MinBalance {
+		return false
+	}
+	if query.MaxBalance != nil && player.Balance > *query.MaxBalance {
+		return false
+	}
+	return true
+}
+
+// Match reports whether the tournament satisfies the query
+func (query TournamentQuery) Match(tournament *model.Tournament) bool {
+	if tournament.Archived != query.Archived {
+		return false
+	}
+	if query.State == Open && tournament.IsFinished ||
+		query.State == Finished && !tournament.IsFinished {
+		return false
+	}
+	if !query.From.IsZero() && tournament.Created.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !tournament.Created.Before(query.To) {
+		return false
+	}
+	if query.Participant == "" {
+		return true
+	}
+	for _, bidder := range tournament.Bidders {
+		if bidder.ID == query.Participant {
+			return true
+		}
+		for _, id := range bidder.Backers {
+			if id == query.Participant {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Match reports whether the operation satisfies the query
+func (query OperationQuery) Match(operation *model.Operation) bool {
+	if query.Kind != "" && operation.Kind != query.Kind {
+		return false
+	}
+	if query.Tournament != 0 && operation.Tournament != query.Tournament {
+		return false
+	}
+	if !query.From.IsZero() && operation.Created.Before(query.From) {
+		return false
+	}
+	if !query.To.IsZero() && !operation.Created.Before(query.To) {
+		return false
+	}
+	if query.Account == "" {
+		return true
+	}
+	for _, posting := range operation.Postings {
+		if posting.Account == query.Account {
+			return true
+		}
+	}
+	return false
+}
+
+// sortKey contains comparable values of the listed record
+type sortKey struct {
+	Num float64 `json:"n,omitempty"`
+	Str string  `json:"s,omitempty"`
+}
+
+func (key sortKey) less(other sortKey) bool {
+	if key.Num != other.Num {
+		return key.Num < other.Num
+	}
+	return key.Str < other.Str
+}
+
+func (key sortKey) cursor() string {
+	data, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func parseCursor(cursor string) (*sortKey, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	key := new(sortKey)
+	if err := json.Unmarshal(data, key); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return key, nil
+}
+
+func (query PlayerQuery) key(player *model.Player) sortKey {
+	if query.SortBy == PlayersByBalance {
+		return sortKey{Num: float64(player.Balance), Str: player.ID}
+	}
+	return sortKey{Str: player.ID}
+}
+
+func (query TournamentQuery) key(tournament *model.Tournament) sortKey {
+	if query.SortBy == TournamentsByDate {
+		return sortKey{
+			Num: float64(tournament.Created.Unix()),
+			Str: fmt.Sprintf("%09d:%020d", tournament.Created.Nanosecond(), tournament.ID),
+		}
+	}
+	return sortKey{Str: fmt.Sprintf("%020d", tournament.ID)}
+}
+
+func (query OperationQuery) key(operation *model.Operation) sortKey {
+	return sortKey{
+		Num: float64(operation.Created.Unix()),
+		Str: fmt.Sprintf("%09d:%s", operation.Created.Nanosecond(), operation.ID),
+	}
+}
+
+// paginate sorts keys in the page order and returns indexes of the keys
+// which are placed on the page and the cursor of the next page if it exists
+func paginate(keys []sortKey, page Page) ([]int, string, error) {
+	order := make([]int, len(keys))
+	for idx := range order {
+		order[idx] = idx
+	}
+	precedes := func(a, b sortKey) bool {
+		if page.Order == Descending {
+			return b.less(a)
+		}
+		return a.less(b)
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return precedes(keys[order[i]], keys[order[j]])
+	})
+	start := 0
+	if page.Cursor != "" {
+		cursor, err := parseCursor(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(order), func(idx int) bool {
+			return precedes(*cursor, keys[order[idx]])
+		})
+	}
+	limit := page.Limit
+	if limit <= 0 {
+		limit = DefaultLimit
+	}
+	end := start + limit
+	if end >= len(order) {
+		return order[start:], "", nil
+	}
+	return order[start:end], keys[order[end-1]].cursor(), nil
+}
+
+// rank sets ranks of the scores sorted from the highest value, equal values share the same rank
+func rank(scores []model.Score) {
+	for idx := range scores {
+		scores[idx].Rank = idx + 1
+		if idx > 0 && scores[idx].Value == scores[idx-1].Value {
+			scores[idx].Rank = scores[idx-1].Rank
+		}
+	}
+}
@@ -0,0 +1,1246 @@
+package datastore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrTransactionDone appears if the transaction was already committed or rolled back
+	ErrTransactionDone = errors.New("Transaction has already been committed or rolled back")
+	// ErrUnexpectedReply appears if Redis replies with unexpected type of value
+	ErrUnexpectedReply = errors.New("Unexpected reply from Redis")
+	// ErrLockTimeout appears if the lock could not be taken during LockTimeout,
+	// it is not repeated by WithTransaction
+	ErrLockTimeout = errors.New("Lock wait timeout exceeded")
+)
+
+// unlockScript deletes the lock key atomically only if it still keeps the token of the transaction,
+// so the lock which expired and was taken by another transaction is not released
+const unlockScript = "if redis.call('get',KEYS[1])==ARGV[1] then return redis.call('del',KEYS[1]) end return 0"
+
+// RedisConn defines a connection to Redis which is compatible with the most of Redis clients,
+// replies have types: status as string, integer as int64, bulk string as []byte,
+// array as []interface{} and nil for the nil reply
+type RedisConn interface {
+	Do(command string, args ...interface{}) (interface{}, error)
+	Close() error
+}
+
+// Redis controller keeps players and tournaments in Redis as JSON documents,
+// audit entries of the players are kept in lists.
+// Transactions are optimistic: every record which is read in the transaction
+// is watched and all changes are applied at once with MULTI/EXEC on commit,
+// the commit fails with ConflictError if any watched record was changed concurrently.
+// Records which are found for update are locked with expiring lock keys,
+// a lock which could not be taken during LockTimeout is reported as ErrLockTimeout.
+// The lock keys are watched as well, so the commit fails with ConflictError
+// if a lock expired after LockTTL and could be taken by another transaction.
+type Redis struct {
+	Prefix      string
+	Dial        func() (RedisConn, error)
+	LockTTL     time.Duration
+	LockTimeout time.Duration
+}
+
+// redisTransact keeps watched connection, pending changes and locks of the transaction
+type redisTransact struct {
+	ctx        context.Context
+	redis      *Redis
+	conn       RedisConn
+	done       bool
+	queue      [][]interface{}
+	savepoints savepoints
+	values     map[string][]byte
+	members    map[string]map[string]bool
+	scores     map[string]map[string]*float64
+	appended   map[string][][]byte
+	locks      map[string]string
+}
+
+// NewRedis returns Redis controller which keeps keys with specified prefix
+func NewRedis(prefix string, dial func() (RedisConn, error)) *Redis {
+	return &Redis{
+		Prefix:      prefix,
+		Dial:        dial,
+		LockTTL:     30 * time.Second,
+		LockTimeout: 5 * time.Second,
+	}
+}
+
+// Ready returns connection state
+func (r *Redis) Ready() bool {
+	conn, err := r.Dial()
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	reply, err := conn.Do("PING")
+	return err == nil && reply == "PONG"
+}
+
+// Reset removes all keys with the controller prefix
+func (r *Redis) Reset() error {
+	conn, err := r.Dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	keys, err := replyStrings(conn.Do("KEYS", r.Prefix+"*"))
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(keys))
+	for idx, key := range keys {
+		args[idx] = key
+	}
+	_, err = conn.Do("DEL", args...)
+	return err
+}
+
+// MigrateUp migrates DB schema, Redis does not need any schema
+func (r *Redis) MigrateUp() error {
+	return nil
+}
+
+// MigrateDown remove DB schema and data
+func (r *Redis) MigrateDown() error {
+	return r.Reset()
+}
+
+// Transaction returns DB transaction control,
+// the returned transaction is already finished if an error appears
+func (r *Redis) Transaction() (Transact, error) {
+	return r.TransactionContext(context.Background())
+}
+
+// TransactionContext returns DB transaction control bound to the context,
+// the returned transaction is already finished if an error appears
+func (r *Redis) TransactionContext(ctx context.Context) (Transact, error) {
+	tx := &redisTransact{
+		ctx:      ctx,
+		redis:    r,
+		values:   make(map[string][]byte),
+		members:  make(map[string]map[string]bool),
+		scores:   make(map[string]map[string]*float64),
+		appended: make(map[string][][]byte),
+		locks:    make(map[string]string),
+	}
+	if err := ctx.Err(); err != nil {
+		tx.done = true
+		return tx, err
+	}
+	conn, err := r.Dial()
+	if err != nil {
+		tx.done = true
+		return tx, err
+	}
+	tx.conn = conn
+	return tx, nil
+}
+
+// Commit applies all changes of the transaction atomically,
+// the changes are discarded if the transaction context is done
+func (tx *redisTransact) Commit() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	defer tx.close()
+	if err := tx.ctx.Err(); err != nil {
+		return err
+	}
+	if len(tx.queue) == 0 {
+		return nil
+	}
+	if _, err := tx.conn.Do("MULTI"); err != nil {
+		return err
+	}
+	for _, command := range tx.queue {
+		if _, err := tx.conn.Do(command[0].(string), command[1:]...); err != nil {
+			tx.conn.Do("DISCARD")
+			return err
+		}
+	}
+	reply, err := tx.conn.Do("EXEC")
+	if err != nil {
+		return err
+	}
+	if reply == nil {
+		return &ConflictError{Record: "transaction"}
+	}
+	replies, ok := reply.([]interface{})
+	if !ok {
+		return ErrUnexpectedReply
+	}
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards all changes of the transaction
+func (tx *redisTransact) Rollback() error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	tx.close()
+	return nil
+}
+
+// Savepoint marks the current state of the transaction
+func (tx *redisTransact) Savepoint(name string) error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	tx.savepoints = append(tx.savepoints, savepoint{name: name, mark: len(tx.queue)})
+	return nil
+}
+
+// ReleaseSavepoint destroys the savepoint keeping changes made after it
+func (tx *redisTransact) ReleaseSavepoint(name string) error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	idx, err := tx.savepoints.find(name)
+	if err != nil {
+		return err
+	}
+	tx.savepoints = tx.savepoints[:idx]
+	return nil
+}
+
+// RollbackTo discards changes made after the savepoint,
+// keys read after the savepoint are still watched until the end of the transaction
+func (tx *redisTransact) RollbackTo(name string) error {
+	if tx.done {
+		return ErrTransactionDone
+	}
+	idx, err := tx.savepoints.find(name)
+	if err != nil {
+		return err
+	}
+	tx.queue = tx.queue[:tx.savepoints[idx].mark]
+	tx.savepoints = tx.savepoints[:idx+1]
+	tx.replay()
+	return nil
+}
+
+// replay rebuilds pending changes of the transaction from the queue of commands
+func (tx *redisTransact) replay() {
+	tx.values = make(map[string][]byte)
+	tx.members = make(map[string]map[string]bool)
+	tx.scores = make(map[string]map[string]*float64)
+	tx.appended = make(map[string][][]byte)
+	for _, command := range tx.queue {
+		key := command[1].(string)
+		switch command[0] {
+		case "SET":
+			tx.values[key] = command[2].([]byte)
+		case "DEL":
+			tx.values[key] = nil
+		case "SADD", "SREM":
+			if tx.members[key] == nil {
+				tx.members[key] = make(map[string]bool)
+			}
+			tx.members[key][command[2].(string)] = command[0] == "SADD"
+		case "ZADD":
+			value, _ := strconv.ParseFloat(command[2].(string), 64)
+			tx.score(key, command[3].(string), &value)
+		case "ZREM":
+			tx.score(key, command[2].(string), nil)
+		case "RPUSH":
+			tx.appended[key] = append(tx.appended[key], command[2].([]byte))
+		}
+	}
+}
+
+// close releases locks and the connection of the transaction
+func (tx *redisTransact) close() {
+	tx.done = true
+	tx.queue = nil
+	tx.savepoints = nil
+	tx.conn.Do("UNWATCH")
+	for key, token := range tx.locks {
+		tx.conn.Do("EVAL", unlockScript, 1, key, token)
+	}
+	tx.conn.Close()
+}
+
+// read returns the value of the key including pending changes,
+// the key is watched until the end of the transaction if watch is set
+func (tx *redisTransact) read(key string, watch bool) ([]byte, error) {
+	if value, ok := tx.values[key]; ok {
+		return value, nil
+	}
+	if watch {
+		if _, err := tx.conn.Do("WATCH", key); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := tx.conn.Do("GET", key)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value := replyBytes(reply)
+	if value == nil {
+		return nil, ErrUnexpectedReply
+	}
+	return value, nil
+}
+
+// readAll returns values of the keys including pending changes with one MGET round trip,
+// values of missing keys are nil, the keys are not watched
+func (tx *redisTransact) readAll(keys []string) ([][]byte, error) {
+	values := make([][]byte, len(keys))
+	args := make([]interface{}, 0, len(keys))
+	stored := make([]int, 0, len(keys))
+	for idx, key := range keys {
+		if value, ok := tx.values[key]; ok {
+			values[idx] = value
+			continue
+		}
+		args = append(args, key)
+		stored = append(stored, idx)
+	}
+	if len(args) == 0 {
+		return values, nil
+	}
+	reply, err := tx.conn.Do("MGET", args...)
+	if err != nil {
+		return nil, err
+	}
+	replies, ok := reply.([]interface{})
+	if !ok || len(replies) != len(args) {
+		return nil, ErrUnexpectedReply
+	}
+	for idx, reply := range replies {
+		if reply == nil {
+			continue
+		}
+		value := replyBytes(reply)
+		if value == nil {
+			return nil, ErrUnexpectedReply
+		}
+		values[stored[idx]] = value
+	}
+	return values, nil
+}
+
+// load reads JSON document of the key into the record, false is returned for missing key
+func (tx *redisTransact) load(key string, record interface{}, watch bool) (bool, error) {
+	value, err := tx.read(key, watch)
+	if err != nil || value == nil {
+		return false, err
+	}
+	return true, json.Unmarshal(value, record)
+}
+
+// store puts JSON document of the record into the key on commit
+func (tx *redisTransact) store(key string, record interface{}) error {
+	return tx.storeFor(key, record, 0)
+}
+
+// storeFor puts JSON document of the record into the key on commit,
+// the key expires after the time to live if it is set
+func (tx *redisTransact) storeFor(key string, record interface{}, ttl time.Duration) error {
+	value, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	tx.values[key] = value
+	command := []interface{}{"SET", key, value}
+	if ttl > 0 {
+		command = append(command, "PX", int64(ttl/time.Millisecond))
+	}
+	tx.queue = append(tx.queue, command)
+	return nil
+}
+
+// remove deletes the key on commit
+func (tx *redisTransact) remove(key string) {
+	tx.values[key] = nil
+	tx.queue = append(tx.queue, []interface{}{"DEL", key})
+}
+
+// index adds or removes the member of the set on commit
+func (tx *redisTransact) index(set, member string, add bool) {
+	if tx.members[set] == nil {
+		tx.members[set] = make(map[string]bool)
+	}
+	tx.members[set][member] = add
+	command := "SREM"
+	if add {
+		command = "SADD"
+	}
+	tx.queue = append(tx.queue, []interface{}{command, set, member})
+}
+
+// score keeps the pending value of the member of the sorted set, nil value means removed member
+func (tx *redisTransact) score(set, member string, value *float64) {
+	if tx.scores[set] == nil {
+		tx.scores[set] = make(map[string]*float64)
+	}
+	tx.scores[set][member] = value
+}
+
+// list returns members of the set including pending changes
+func (tx *redisTransact) list(set string) ([]string, error) {
+	members, err := replyStrings(tx.conn.Do("SMEMBERS", set))
+	if err != nil {
+		return nil, err
+	}
+	list := make([]string, 0, len(members))
+	for _, member := range members {
+		if added, ok := tx.members[set][member]; !ok || added {
+			list = append(list, member)
+		}
+	}
+	for member, added := range tx.members[set] {
+		if added && !contains(members, member) {
+			list = append(list, member)
+		}
+	}
+	return list, nil
+}
+
+// lock takes the lock key until the end of the transaction,
+// the transaction is rolled back if its context is done while waiting
+func (tx *redisTransact) lock(key string) error {
+	if _, ok := tx.locks[key]; ok {
+		return nil
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return err
+	}
+	value := hex.EncodeToString(token)
+	ttl := int64(tx.redis.LockTTL / time.Millisecond)
+	deadline := time.Now().Add(tx.redis.LockTimeout)
+	for {
+		reply, err := tx.conn.Do("SET", key, value, "NX", "PX", ttl)
+		if err != nil {
+			return err
+		}
+		if reply != nil {
+			tx.locks[key] = value
+			_, err := tx.conn.Do("WATCH", key)
+			return err
+		}
+		if !time.Now().Before(deadline) {
+			return ErrLockTimeout
+		}
+		select {
+		case <-time.After(5 * time.Millisecond):
+		case <-tx.ctx.Done():
+			tx.close()
+			return tx.ctx.Err()
+		}
+	}
+}
+
+// run executes fn in the transaction or in a new one if tx is not Redis transaction,
+// the transaction is rolled back if its context is done
+func (r *Redis) run(tx Transact, fn func(tx *redisTransact) error) error {
+	if transact, ok := tx.(*redisTransact); ok {
+		if transact.done {
+			return ErrTransactionDone
+		}
+		if err := transact.ctx.Err(); err != nil {
+			transact.close()
+			return err
+		}
+		return fn(transact)
+	}
+	created, err := r.Transaction()
+	if err != nil {
+		return err
+	}
+	transact := created.(*redisTransact)
+	if err := fn(transact); err != nil {
+		transact.Rollback()
+		return err
+	}
+	return transact.Commit()
+}
+
+func (r *Redis) playerKey(ID string) string {
+	return r.Prefix + "player:" + ID
+}
+
+func (r *Redis) tournamentKey(ID uint64) string {
+	return r.Prefix + "tournament:" + strconv.FormatUint(ID, 10)
+}
+
+// NewPlayer creates a new player with specified ID
+func (r *Redis) NewPlayer(ID string, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := tx.read(r.playerKey(ID), true)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			return ErrAlreadyExist
+		}
+		tx.index(r.Prefix+"players", ID, true)
+		return tx.store(r.playerKey(ID), model.Player{ID: ID})
+	})
+}
+
+// FindPlayer finds existing player by specified ID
+func (r *Redis) FindPlayer(ID string, tx Transact) (*model.Player, error) {
+	player := new(model.Player)
+	err := r.run(tx, func(tx *redisTransact) error {
+		ok, err := tx.load(r.playerKey(ID), player, true)
+		if err == nil && (!ok || player.Archived) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return player, nil
+}
+
+// FindPlayerForUpdate finds existing player by specified ID
+// and locks it until the end of the transaction
+func (r *Redis) FindPlayerForUpdate(ID string, tx Transact) (*model.Player, error) {
+	if transact, ok := tx.(*redisTransact); ok && !transact.done {
+		if err := transact.lock(r.playerKey(ID) + ":lock"); err != nil {
+			return nil, err
+		}
+	}
+	return r.FindPlayer(ID, tx)
+}
+
+// SavePlayer saves a Player model
+func (r *Redis) SavePlayer(player *model.Player, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		stored := new(model.Player)
+		ok, err := tx.load(r.playerKey(player.ID), stored, true)
+		if err != nil {
+			return err
+		}
+		if ok && stored.Version != player.Version {
+			return &ConflictError{
+				Record:  "player",
+				ID:      player.ID,
+				Version: player.Version,
+				Stored:  stored.Version,
+			}
+		}
+		if !ok {
+			tx.index(r.Prefix+"players", player.ID, true)
+		}
+		player.Version++
+		return tx.store(r.playerKey(player.ID), player)
+	})
+}
+
+// ListPlayers returns a page of players which satisfy the query and the next page cursor
+func (r *Redis) ListPlayers(query PlayerQuery, tx Transact) ([]model.Player, string, error) {
+	var page []model.Player
+	var next string
+	err := r.run(tx, func(tx *redisTransact) error {
+		ids, err := tx.list(r.Prefix + "players")
+		if err != nil {
+			return err
+		}
+		records := make([]string, len(ids))
+		for idx, id := range ids {
+			records[idx] = r.playerKey(id)
+		}
+		values, err := tx.readAll(records)
+		if err != nil {
+			return err
+		}
+		players := make([]model.Player, 0)
+		keys := make([]sortKey, 0)
+		for _, value := range values {
+			var player model.Player
+			if value == nil {
+				continue
+			}
+			if err := json.Unmarshal(value, &player); err != nil {
+				return err
+			}
+			if query.Match(&player) {
+				players = append(players, player)
+				keys = append(keys, query.key(&player))
+			}
+		}
+		order, cursor, err := paginate(keys, query.Page)
+		if err != nil {
+			return err
+		}
+		page = make([]model.Player, 0, len(order))
+		for _, idx := range order {
+			page = append(page, players[idx])
+		}
+		next = cursor
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// DeletePlayer delete player by specified ID with its statistics and lots, the audit of the player is kept
+func (r *Redis) DeletePlayer(ID string, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := tx.read(r.playerKey(ID), true)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return ErrRecordNotFound
+		}
+		tx.index(r.Prefix+"players", ID, false)
+		tx.remove(r.playerKey(ID))
+		tx.remove(r.statsKey(ID))
+		tx.remove(r.lotsKey(ID))
+		return nil
+	})
+}
+
+func (r *Redis) auditKey(player string) string {
+	return r.Prefix + "audit:" + player
+}
+
+func (r *Redis) statsKey(player string) string {
+	return r.Prefix + "stats:" + player
+}
+
+func (r *Redis) lotsKey(player string) string {
+	return r.Prefix + "lots:" + player
+}
+
+// NewAuditEntry adds the entry to the end of the audit of the player on commit
+func (r *Redis) NewAuditEntry(player string, entry *model.AuditEntry, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		key := r.auditKey(player)
+		tx.appended[key] = append(tx.appended[key], value)
+		tx.queue = append(tx.queue, []interface{}{"RPUSH", key, value})
+		return nil
+	})
+}
+
+// ListAudit returns the audit of the player in the order the entries were added including pending entries
+func (r *Redis) ListAudit(player string, tx Transact) ([]model.AuditEntry, error) {
+	var audit []model.AuditEntry
+	err := r.run(tx, func(tx *redisTransact) error {
+		key := r.auditKey(player)
+		reply, err := tx.conn.Do("LRANGE", key, 0, -1)
+		if err != nil {
+			return err
+		}
+		replies, ok := reply.([]interface{})
+		if !ok {
+			return ErrUnexpectedReply
+		}
+		values := make([][]byte, 0, len(replies)+len(tx.appended[key]))
+		for _, reply := range replies {
+			value := replyBytes(reply)
+			if value == nil {
+				return ErrUnexpectedReply
+			}
+			values = append(values, value)
+		}
+		values = append(values, tx.appended[key]...)
+		audit = make([]model.AuditEntry, len(values))
+		for idx, value := range values {
+			if err := json.Unmarshal(value, &audit[idx]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return audit, nil
+}
+
+// FindStats finds statistics of the player
+func (r *Redis) FindStats(player string, tx Transact) (*model.Stats, error) {
+	stats := new(model.Stats)
+	err := r.run(tx, func(tx *redisTransact) error {
+		ok, err := tx.load(r.statsKey(player), stats, true)
+		if err == nil && !ok {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// SaveStats saves statistics of the player on commit
+func (r *Redis) SaveStats(player string, stats *model.Stats, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		return tx.store(r.statsKey(player), stats)
+	})
+}
+
+// FindLots finds lots of the player, the player without lots has an empty list
+func (r *Redis) FindLots(player string, tx Transact) ([]model.Lot, error) {
+	lots := make([]model.Lot, 0)
+	err := r.run(tx, func(tx *redisTransact) error {
+		_, err := tx.load(r.lotsKey(player), &lots, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return lots, nil
+}
+
+// SaveLots replaces lots of the player on commit, empty lots are removed
+func (r *Redis) SaveLots(player string, lots []model.Lot, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		if len(lots) == 0 {
+			tx.remove(r.lotsKey(player))
+			return nil
+		}
+		return tx.store(r.lotsKey(player), lots)
+	})
+}
+
+// ArchivePlayer hides the player from Find and List methods until it is restored
+func (r *Redis) ArchivePlayer(ID string, tx Transact) error {
+	return r.archivePlayer(ID, true, tx)
+}
+
+// RestorePlayer returns archived player back
+func (r *Redis) RestorePlayer(ID string, tx Transact) error {
+	return r.archivePlayer(ID, false, tx)
+}
+
+func (r *Redis) archivePlayer(ID string, archived bool, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		player := new(model.Player)
+		ok, err := tx.load(r.playerKey(ID), player, true)
+		if err != nil {
+			return err
+		}
+		if !ok || player.Archived == archived {
+			return ErrRecordNotFound
+		}
+		player.Archived = archived
+		player.Version++
+		return tx.store(r.playerKey(ID), player)
+	})
+}
+
+// NewTournament creates a new tournament with specified ID
+func (r *Redis) NewTournament(ID uint64, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := tx.read(r.tournamentKey(ID), true)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			return ErrAlreadyExist
+		}
+		tx.index(r.Prefix+"tournaments", strconv.FormatUint(ID, 10), true)
+		return tx.store(r.tournamentKey(ID), model.Tournament{
+			ID: ID, Bidders: make([]model.Bidder, 0), Created: time.Now(),
+		})
+	})
+}
+
+// FindTournament finds existing tournament by specified ID
+func (r *Redis) FindTournament(ID uint64, tx Transact) (*model.Tournament, error) {
+	tournament := new(model.Tournament)
+	err := r.run(tx, func(tx *redisTransact) error {
+		ok, err := tx.load(r.tournamentKey(ID), tournament, true)
+		if err == nil && (!ok || tournament.Archived) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tournament, nil
+}
+
+// FindTournamentForUpdate finds existing tournament by specified ID
+// and locks it until the end of the transaction
+func (r *Redis) FindTournamentForUpdate(ID uint64, tx Transact) (*model.Tournament, error) {
+	if transact, ok := tx.(*redisTransact); ok && !transact.done {
+		if err := transact.lock(r.tournamentKey(ID) + ":lock"); err != nil {
+			return nil, err
+		}
+	}
+	return r.FindTournament(ID, tx)
+}
+
+// SaveTournament saves a Tournament model
+func (r *Redis) SaveTournament(tournament *model.Tournament, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		stored := new(model.Tournament)
+		ok, err := tx.load(r.tournamentKey(tournament.ID), stored, true)
+		if err != nil {
+			return err
+		}
+		if ok && stored.Version != tournament.Version {
+			return &ConflictError{
+				Record:  "tournament",
+				ID:      strconv.FormatUint(tournament.ID, 10),
+				Version: tournament.Version,
+				Stored:  stored.Version,
+			}
+		}
+		if !ok {
+			tx.index(r.Prefix+"tournaments", strconv.FormatUint(tournament.ID, 10), true)
+		}
+		tournament.Version++
+		return tx.store(r.tournamentKey(tournament.ID), tournament)
+	})
+}
+
+// ListTournaments returns a page of tournaments which satisfy the query and the next page cursor
+func (r *Redis) ListTournaments(query TournamentQuery, tx Transact) ([]model.Tournament, string, error) {
+	var page []model.Tournament
+	var next string
+	err := r.run(tx, func(tx *redisTransact) error {
+		ids, err := tx.list(r.Prefix + "tournaments")
+		if err != nil {
+			return err
+		}
+		records := make([]string, len(ids))
+		for idx, id := range ids {
+			records[idx] = r.Prefix + "tournament:" + id
+		}
+		values, err := tx.readAll(records)
+		if err != nil {
+			return err
+		}
+		tournaments := make([]model.Tournament, 0)
+		keys := make([]sortKey, 0)
+		for _, value := range values {
+			var tournament model.Tournament
+			if value == nil {
+				continue
+			}
+			if err := json.Unmarshal(value, &tournament); err != nil {
+				return err
+			}
+			if query.Match(&tournament) {
+				tournaments = append(tournaments, tournament)
+				keys = append(keys, query.key(&tournament))
+			}
+		}
+		order, cursor, err := paginate(keys, query.Page)
+		if err != nil {
+			return err
+		}
+		page = make([]model.Tournament, 0, len(order))
+		for _, idx := range order {
+			page = append(page, tournaments[idx])
+		}
+		next = cursor
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+// DeleteTournament delete tournament by specified ID
+func (r *Redis) DeleteTournament(ID uint64, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := tx.read(r.tournamentKey(ID), true)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			return ErrRecordNotFound
+		}
+		tx.index(r.Prefix+"tournaments", strconv.FormatUint(ID, 10), false)
+		tx.remove(r.tournamentKey(ID))
+		return nil
+	})
+}
+
+// ArchiveTournament hides the tournament from Find and List methods until it is restored
+func (r *Redis) ArchiveTournament(ID uint64, tx Transact) error {
+	return r.archiveTournament(ID, true, tx)
+}
+
+// RestoreTournament returns archived tournament back
+func (r *Redis) RestoreTournament(ID uint64, tx Transact) error {
+	return r.archiveTournament(ID, false, tx)
+}
+
+func (r *Redis) archiveTournament(ID uint64, archived bool, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		tournament := new(model.Tournament)
+		ok, err := tx.load(r.tournamentKey(ID), tournament, true)
+		if err != nil {
+			return err
+		}
+		if !ok || tournament.Archived == archived {
+			return ErrRecordNotFound
+		}
+		tournament.Archived = archived
+		tournament.Version++
+		return tx.store(r.tournamentKey(ID), tournament)
+	})
+}
+
+func (r *Redis) operationKey(ID string) string {
+	return r.Prefix + "operation:" + ID
+}
+
+// NewOperation creates a new ledger operation,
+// the operation is indexed by all accounts of its postings
+func (r *Redis) NewOperation(operation *model.Operation, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		value, err := tx.read(r.operationKey(operation.ID), true)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			return ErrAlreadyExist
+		}
+		tx.index(r.Prefix+"operations", operation.ID, true)
+		for _, posting := range operation.Postings {
+			tx.index(r.Prefix+"account:"+posting.Account, operation.ID, true)
+		}
+		return tx.store(r.operationKey(operation.ID), operation)
+	})
+}
+
+// FindOperation finds existing ledger operation by specified ID
+func (r *Redis) FindOperation(ID string, tx Transact) (*model.Operation, error) {
+	operation := new(model.Operation)
+	err := r.run(tx, func(tx *redisTransact) error {
+		ok, err := tx.load(r.operationKey(ID), operation, false)
+		if err == nil && !ok {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+// ListOperations returns a page of ledger operations which satisfy the query and the next page cursor
+func (r *Redis) ListOperations(query OperationQuery, tx Transact) ([]model.Operation, string, error) {
+	var page []model.Operation
+	var next string
+	err := r.run(tx, func(tx *redisTransact) error {
+		set := r.Prefix + "operations"
+		if query.Account != "" {
+			set = r.Prefix + "account:" + query.Account
+		}
+		ids, err := tx.list(set)
+		if err != nil {
+			return err
+		}
+		records := make([]string, len(ids))
+		for idx, id := range ids {
+			records[idx] = r.operationKey(id)
+		}
+		values, err := tx.readAll(records)
+		if err != nil {
+			return err
+		}
+		operations := make([]model.Operation, 0)
+		keys := make([]sortKey, 0)
+		for _, value := range values {
+			var operation model.Operation
+			if value == nil {
+				continue
+			}
+			if err := json.Unmarshal(value, &operation); err != nil {
+				return err
+			}
+			if query.Match(&operation) {
+				operations = append(operations, operation)
+				keys = append(keys, query.key(&operation))
+			}
+		}
+		order, cursor, err := paginate(keys, query.Page)
+		if err != nil {
+			return err
+		}
+		page = make([]model.Operation, 0, len(order))
+		for _, idx := range order {
+			page = append(page, operations[idx])
+		}
+		next = cursor
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return page, next, nil
+}
+
+func (r *Redis) idempotencyKey(key string) string {
+	return r.Prefix + "idempotency:" + key
+}
+
+// NewIdempotency keeps the outcome of the request done with the idempotency key,
+// the record expires after the retention window
+func (r *Redis) NewIdempotency(record *model.Idempotency, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		stored := new(model.Idempotency)
+		ok, err := tx.load(r.idempotencyKey(record.Key), stored, true)
+		if err != nil {
+			return err
+		}
+		if ok && !expired(stored) {
+			return ErrAlreadyExist
+		}
+		return tx.storeFor(r.idempotencyKey(record.Key), record, IdempotencyRetention)
+	})
+}
+
+// FindIdempotency finds the outcome of the request done with the idempotency key within the retention window
+func (r *Redis) FindIdempotency(key string, tx Transact) (*model.Idempotency, error) {
+	record := new(model.Idempotency)
+	err := r.run(tx, func(tx *redisTransact) error {
+		ok, err := tx.load(r.idempotencyKey(key), record, true)
+		if err == nil && (!ok || expired(record)) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (r *Redis) boardKey(board string) string {
+	return r.Prefix + "board:" + board
+}
+
+// SaveScore sets the value of the member in the board on commit
+func (r *Redis) SaveScore(board, member string, value float64, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		tx.score(r.boardKey(board), member, &value)
+		tx.queue = append(tx.queue, []interface{}{
+			"ZADD", r.boardKey(board), strconv.FormatFloat(value, 'g', -1, 64), member,
+		})
+		return nil
+	})
+}
+
+// FindScore finds the value and the rank of the member in the board including pending changes
+func (r *Redis) FindScore(board, member string, tx Transact) (*model.Score, error) {
+	var score *model.Score
+	err := r.run(tx, func(tx *redisTransact) error {
+		key := r.boardKey(board)
+		value, ok := tx.scores[key][member]
+		if !ok {
+			stored, err := tx.value(key, member)
+			if err != nil {
+				return err
+			}
+			value = stored
+		}
+		if value == nil {
+			return ErrRecordNotFound
+		}
+		reply, err := tx.conn.Do("ZCOUNT", key, "("+strconv.FormatFloat(*value, 'g', -1, 64), "+inf")
+		if err != nil {
+			return err
+		}
+		higher, ok := reply.(int64)
+		if !ok {
+			return ErrUnexpectedReply
+		}
+		// members with pending changes are counted by their new values
+		for other, pending := range tx.scores[key] {
+			stored, err := tx.value(key, other)
+			if err != nil {
+				return err
+			}
+			if stored != nil && *stored > *value {
+				higher--
+			}
+			if pending != nil && *pending > *value {
+				higher++
+			}
+		}
+		score = &model.Score{Board: board, Member: member, Value: *value, Rank: int(higher) + 1}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return score, nil
+}
+
+// FindScoreValue finds the value of the member in the board including pending changes
+func (r *Redis) FindScoreValue(board, member string, tx Transact) (float64, error) {
+	var score float64
+	err := r.run(tx, func(tx *redisTransact) error {
+		key := r.boardKey(board)
+		value, ok := tx.scores[key][member]
+		if !ok {
+			stored, err := tx.value(key, member)
+			if err != nil {
+				return err
+			}
+			value = stored
+		}
+		if value == nil {
+			return ErrRecordNotFound
+		}
+		score = *value
+		return nil
+	})
+	return score, err
+}
+
+// ListScores returns up to count scores of the board with the highest values including pending changes,
+// members with equal values are ordered in reverse order of their names, negative count lists all scores
+func (r *Redis) ListScores(board string, count int, tx Transact) ([]model.Score, error) {
+	var scores []model.Score
+	err := r.run(tx, func(tx *redisTransact) error {
+		key := r.boardKey(board)
+		scores = make([]model.Score, 0)
+		if count == 0 {
+			return nil
+		}
+		// stored members with pending changes are replaced, so they are read in addition to the count
+		stop := -1
+		if count > 0 {
+			stop = count + len(tx.scores[key]) - 1
+		}
+		values, err := replyStrings(tx.conn.Do("ZREVRANGE", key, 0, stop, "WITHSCORES"))
+		if err != nil {
+			return err
+		}
+		for idx := 0; idx+1 < len(values); idx += 2 {
+			if _, ok := tx.scores[key][values[idx]]; ok {
+				continue
+			}
+			value, err := strconv.ParseFloat(values[idx+1], 64)
+			if err != nil {
+				return ErrUnexpectedReply
+			}
+			scores = append(scores, model.Score{Board: board, Member: values[idx], Value: value})
+		}
+		for member, value := range tx.scores[key] {
+			if value != nil {
+				scores = append(scores, model.Score{Board: board, Member: member, Value: *value})
+			}
+		}
+		sort.Slice(scores, func(i, j int) bool {
+			return scores[i].Value > scores[j].Value ||
+				scores[i].Value == scores[j].Value && scores[i].Member > scores[j].Member
+		})
+		if count > 0 && count < len(scores) {
+			scores = scores[:count]
+		}
+		rank(scores)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return scores, nil
+}
+
+// DeleteScore removes the member from the board on commit
+func (r *Redis) DeleteScore(board, member string, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		tx.score(r.boardKey(board), member, nil)
+		tx.queue = append(tx.queue, []interface{}{"ZREM", r.boardKey(board), member})
+		return nil
+	})
+}
+
+// ExpireBoard sets the moment when the board is removed with all its scores on commit,
+// the board which has no scores is not changed
+func (r *Redis) ExpireBoard(board string, at time.Time, tx Transact) error {
+	return r.run(tx, func(tx *redisTransact) error {
+		tx.queue = append(tx.queue, []interface{}{
+			"PEXPIREAT", r.boardKey(board), at.UnixNano() / int64(time.Millisecond),
+		})
+		return nil
+	})
+}
+
+// value returns the stored score of the member of the sorted set or nil if the member does not exist
+func (tx *redisTransact) value(set, member string) (*float64, error) {
+	reply, err := tx.conn.Do("ZSCORE", set, member)
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	value, err := strconv.ParseFloat(string(replyBytes(reply)), 64)
+	if err != nil {
+		return nil, ErrUnexpectedReply
+	}
+	return &value, nil
+}
+
+// replyBytes converts bulk string reply into bytes, nil is returned for other types
+func replyBytes(reply interface{}) []byte {
+	switch value := reply.(type) {
+	case []byte:
+		return value
+	case string:
+		return []byte(value)
+	}
+	return nil
+}
+
+// replyStrings converts array reply into strings
+func replyStrings(reply interface{}, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	values, ok := reply.([]interface{})
+	if !ok {
+		return nil, ErrUnexpectedReply
+	}
+	result := make([]string, len(values))
+	for idx, value := range values {
+		data := replyBytes(value)
+		if data == nil {
+			return nil, ErrUnexpectedReply
+		}
+		result[idx] = string(data)
+	}
+	return result, nil
+}
+
+func contains(list []string, item string) bool {
+	for _, value := range list {
+		if value == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,191 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takama/backer/datastore/redistest"
+)
+
+func newRedis(server *redistest.Server) *Redis {
+	redis := NewRedis("test:", func() (RedisConn, error) {
+		return server.Conn(), nil
+	})
+	redis.LockTimeout = 100 * time.Millisecond
+	return redis
+}
+
+func TestRedisRollback(t *testing.T) {
+	testRollback(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisListPlayers(t *testing.T) {
+	testListPlayers(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisListTournaments(t *testing.T) {
+	testListTournaments(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisArchive(t *testing.T) {
+	testArchive(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisCancel(t *testing.T) {
+	testCancel(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisWithTransaction(t *testing.T) {
+	testWithTransaction(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisSavepoints(t *testing.T) {
+	testSavepoints(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisOperations(t *testing.T) {
+	testOperations(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisIdempotency(t *testing.T) {
+	testIdempotency(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisScores(t *testing.T) {
+	testScores(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisPlayerRecords(t *testing.T) {
+	testPlayerRecords(t, newRedis(redistest.NewServer()))
+}
+
+func TestRedisReady(t *testing.T) {
+
+	server := redistest.NewServer()
+	store := newRedis(server)
+	test(t, store.Ready(), "Expected ready connection, got false")
+	err := store.MigrateUp()
+	test(t, err == nil, "Expected migrate up, got", err)
+	store.NewPlayer("p1", nil)
+	other := NewRedis("other:", store.Dial)
+	other.NewPlayer("p1", nil)
+	err = store.MigrateDown()
+	test(t, err == nil, "Expected migrate down, got", err)
+	_, err = store.FindPlayer("p1", nil)
+	test(t, err == ErrRecordNotFound, "Expected", ErrRecordNotFound, "got", err)
+	_, err = other.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player with other prefix, got", err)
+}
+
+func TestRedisConflict(t *testing.T) {
+
+	store := newRedis(redistest.NewServer())
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	tx, _ := store.Transaction()
+	player, err := store.FindPlayer("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	concurrent, _ := store.FindPlayer("p1", nil)
+	concurrent.Balance = 500
+	err = store.SavePlayer(concurrent, nil)
+	test(t, err == nil, "Expected save the player, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx)
+	test(t, IsConflict(err), "Expected conflict error, got", err)
+	tx.Rollback()
+
+	tx, _ = store.Transaction()
+	player, err = store.FindPlayer("p1", tx)
+	test(t, err == nil, "Expected find the player, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx)
+	test(t, err == nil, "Expected save the player, got", err)
+	concurrent, _ = store.FindPlayer("p1", nil)
+	concurrent.Balance = 700
+	store.SavePlayer(concurrent, nil)
+	err = tx.Commit()
+	test(t, IsConflict(err), "Expected conflict error, got", err)
+	test(t, IsRetryable(err), "Expected retryable error, got", err)
+	player, _ = store.FindPlayer("p1", nil)
+	test(t, player.Balance == 700, "Expected 700 points for the player, got", player.Balance)
+	err = tx.Commit()
+	test(t, err == ErrTransactionDone, "Expected", ErrTransactionDone, "got", err)
+}
+
+func TestRedisLocks(t *testing.T) {
+
+	store := newRedis(redistest.NewServer())
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	tx1, _ := store.Transaction()
+	tx2, _ := store.Transaction()
+	_, err := store.FindPlayerForUpdate("p1", tx1)
+	test(t, err == nil, "Expected lock the player, got", err)
+	_, err = store.FindPlayerForUpdate("p1", tx2)
+	test(t, err == ErrLockTimeout, "Expected", ErrLockTimeout, "got", err)
+	test(t, !IsRetryable(err), "Expected not retryable lock timeout")
+
+	locked := make(chan error)
+	go func() {
+		_, err := store.FindPlayerForUpdate("p1", tx2)
+		locked <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	err = tx1.Commit()
+	test(t, err == nil, "Expected commit, got", err)
+	err = <-locked
+	test(t, err == nil, "Expected lock the player, got", err)
+	tx2.Rollback()
+}
+
+func TestRedisExpiredLock(t *testing.T) {
+
+	store := newRedis(redistest.NewServer())
+	store.LockTTL = 20 * time.Millisecond
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	tx1, _ := store.Transaction()
+	_, err := store.FindPlayerForUpdate("p1", tx1)
+	test(t, err == nil, "Expected lock the player, got", err)
+	time.Sleep(2 * store.LockTTL)
+	store.LockTTL = time.Second
+	tx2, _ := store.Transaction()
+	_, err = store.FindPlayerForUpdate("p1", tx2)
+	test(t, err == nil, "Expected lock the player after the expired lock, got", err)
+	tx1.Rollback()
+
+	tx3, _ := store.Transaction()
+	_, err = store.FindPlayerForUpdate("p1", tx3)
+	test(t, err == ErrLockTimeout, "Expected the lock of another transaction is kept, got", err)
+	tx2.Rollback()
+	_, err = store.FindPlayerForUpdate("p1", tx3)
+	test(t, err == nil, "Expected lock the released player, got", err)
+	tx3.Rollback()
+}
+
+func TestRedisLostLock(t *testing.T) {
+
+	store := newRedis(redistest.NewServer())
+	store.LockTTL = 20 * time.Millisecond
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	tx1, _ := store.Transaction()
+	player, err := store.FindPlayerForUpdate("p1", tx1)
+	test(t, err == nil, "Expected lock the player, got", err)
+	time.Sleep(2 * store.LockTTL)
+	tx2, _ := store.Transaction()
+	_, err = store.FindPlayerForUpdate("p1", tx2)
+	test(t, err == nil, "Expected lock the player after the expired lock, got", err)
+	player.Balance = 100
+	err = store.SavePlayer(player, tx1)
+	test(t, err == nil, "Expected save the player, got", err)
+	err = tx1.Commit()
+	test(t, IsConflict(err), "Expected conflict of the transaction which lost the lock, got", err)
+	tx2.Rollback()
+	player, _ = store.FindPlayer("p1", nil)
+	test(t, player.Balance == 0, "Expected the player is not changed, got", player.Balance)
+}
@@ -0,0 +1,641 @@
+// Package redistest provides an in-process Redis stand-in for tests,
+// it supports a subset of Redis commands used by the datastore package
+package redistest
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error is a Redis error reply
+type Error string
+
+// Error implements error interface
+func (err Error) Error() string {
+	return string(err)
+}
+
+const (
+	errSyntax    = Error("ERR syntax error")
+	errWrongType = Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	errNotInt    = Error("ERR value is not an integer or out of range")
+	errNotFloat  = Error("ERR value is not a valid float")
+	errClosed    = Error("ERR connection closed")
+)
+
+// Server keeps Redis data in memory and serves connections
+type Server struct {
+	mutex    sync.Mutex
+	strings  map[string][]byte
+	sets     map[string]map[string]bool
+	zsets    map[string]map[string]float64
+	lists    map[string][][]byte
+	expires  map[string]time.Time
+	versions map[string]uint64
+	version  uint64
+}
+
+// NewServer returns a new empty Server
+func NewServer() *Server {
+	return &Server{
+		strings:  make(map[string][]byte),
+		sets:     make(map[string]map[string]bool),
+		zsets:    make(map[string]map[string]float64),
+		lists:    make(map[string][][]byte),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]uint64),
+	}
+}
+
+// Conn returns a new connection to the Server
+func (server *Server) Conn() *Conn {
+	return &Conn{server: server}
+}
+
+// Conn is a connection to the Server, it is not safe for concurrent use
+// as well as connections of the real Redis clients
+type Conn struct {
+	server  *Server
+	closed  bool
+	watched map[string]uint64
+	multi   bool
+	queue   [][]string
+}
+
+// Close closes the connection
+func (conn *Conn) Close() error {
+	conn.closed = true
+	conn.watched = nil
+	conn.queue = nil
+	conn.multi = false
+	return nil
+}
+
+// Do executes a command, replies follow types of the most of Redis clients:
+// status as string, integer as int64, bulk string as []byte, array as []interface{}
+func (conn *Conn) Do(command string, args ...interface{}) (interface{}, error) {
+	if conn.closed {
+		return nil, errClosed
+	}
+	cmd := strings.ToUpper(command)
+	params := make([]string, len(args))
+	for idx, arg := range args {
+		switch value := arg.(type) {
+		case []byte:
+			params[idx] = string(value)
+		case string:
+			params[idx] = value
+		default:
+			params[idx] = fmt.Sprint(value)
+		}
+	}
+
+	server := conn.server
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	switch cmd {
+	case "MULTI":
+		if conn.multi {
+			return nil, Error("ERR MULTI calls can not be nested")
+		}
+		conn.multi = true
+		return "OK", nil
+	case "EXEC":
+		if !conn.multi {
+			return nil, Error("ERR EXEC without MULTI")
+		}
+		queue, watched := conn.queue, conn.watched
+		conn.multi, conn.queue, conn.watched = false, nil, nil
+		// watched keys which expired abort the transaction as in Redis 6.0.9 and later
+		for key, version := range watched {
+			server.expire(key)
+			if server.versions[key] != version {
+				return nil, nil
+			}
+		}
+		replies := make([]interface{}, len(queue))
+		for idx, params := range queue {
+			reply, err := server.exec(params[0], params[1:])
+			if err != nil {
+				replies[idx] = err
+				continue
+			}
+			replies[idx] = reply
+		}
+		return replies, nil
+	case "DISCARD":
+		if !conn.multi {
+			return nil, Error("ERR DISCARD without MULTI")
+		}
+		conn.multi, conn.queue, conn.watched = false, nil, nil
+		return "OK", nil
+	case "WATCH":
+		if conn.multi {
+			return nil, Error("ERR WATCH inside MULTI is not allowed")
+		}
+		if conn.watched == nil {
+			conn.watched = make(map[string]uint64)
+		}
+		for _, key := range params {
+			if _, ok := conn.watched[key]; !ok {
+				server.expire(key)
+				conn.watched[key] = server.versions[key]
+			}
+		}
+		return "OK", nil
+	case "UNWATCH":
+		conn.watched = nil
+		return "OK", nil
+	}
+	if conn.multi {
+		conn.queue = append(conn.queue, append([]string{cmd}, params...))
+		return "QUEUED", nil
+	}
+	return server.exec(cmd, params)
+}
+
+// touch changes the version of the key, it invalidates watching of the key
+func (server *Server) touch(key string) {
+	server.version++
+	server.versions[key] = server.version
+}
+
+// expire removes the key if its time to live is over
+func (server *Server) expire(key string) {
+	if deadline, ok := server.expires[key]; ok && !time.Now().Before(deadline) {
+		server.remove(key)
+	}
+}
+
+func (server *Server) remove(key string) bool {
+	exists := server.exists(key)
+	delete(server.strings, key)
+	delete(server.sets, key)
+	delete(server.zsets, key)
+	delete(server.lists, key)
+	delete(server.expires, key)
+	if exists {
+		server.touch(key)
+	}
+	return exists
+}
+
+func (server *Server) exec(cmd string, params []string) (interface{}, error) {
+	for _, key := range server.keysOf(cmd, params) {
+		server.expire(key)
+	}
+	switch cmd {
+	case "PING":
+		return "PONG", nil
+	case "FLUSHDB", "FLUSHALL":
+		for key := range server.strings {
+			server.remove(key)
+		}
+		for key := range server.sets {
+			server.remove(key)
+		}
+		for key := range server.zsets {
+			server.remove(key)
+		}
+		for key := range server.lists {
+			server.remove(key)
+		}
+		return "OK", nil
+	case "GET":
+		if len(params) != 1 {
+			return nil, errSyntax
+		}
+		if _, ok := server.strings[params[0]]; !ok && server.exists(params[0]) {
+			return nil, errWrongType
+		}
+		value, ok := server.strings[params[0]]
+		if !ok {
+			return nil, nil
+		}
+		return append([]byte(nil), value...), nil
+	case "SET":
+		return server.set(params)
+	case "MGET":
+		if len(params) == 0 {
+			return nil, errSyntax
+		}
+		reply := make([]interface{}, len(params))
+		for idx, key := range params {
+			if value, ok := server.strings[key]; ok {
+				reply[idx] = append([]byte(nil), value...)
+			}
+		}
+		return reply, nil
+	case "DEL":
+		var count int64
+		for _, key := range params {
+			if server.remove(key) {
+				count++
+			}
+		}
+		return count, nil
+	case "EXISTS":
+		var count int64
+		for _, key := range params {
+			if server.exists(key) {
+				count++
+			}
+		}
+		return count, nil
+	case "KEYS":
+		if len(params) != 1 {
+			return nil, errSyntax
+		}
+		keys := make([]string, 0)
+		for key := range server.strings {
+			keys = append(keys, key)
+		}
+		for key := range server.sets {
+			keys = append(keys, key)
+		}
+		for key := range server.zsets {
+			keys = append(keys, key)
+		}
+		for key := range server.lists {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		reply := make([]interface{}, 0)
+		for _, key := range keys {
+			server.expire(key)
+			if server.exists(key) && match(params[0], key) {
+				reply = append(reply, []byte(key))
+			}
+		}
+		return reply, nil
+	case "SADD", "SREM":
+		if len(params) < 2 {
+			return nil, errSyntax
+		}
+		if _, ok := server.sets[params[0]]; !ok && server.exists(params[0]) {
+			return nil, errWrongType
+		}
+		set, ok := server.sets[params[0]]
+		if !ok {
+			set = make(map[string]bool)
+		}
+		var count int64
+		for _, member := range params[1:] {
+			if set[member] == (cmd == "SREM") {
+				count++
+			}
+			if cmd == "SADD" {
+				set[member] = true
+			} else {
+				delete(set, member)
+			}
+		}
+		if len(set) == 0 {
+			server.remove(params[0])
+		} else {
+			server.sets[params[0]] = set
+		}
+		if count > 0 {
+			server.touch(params[0])
+		}
+		return count, nil
+	case "SMEMBERS":
+		if len(params) != 1 {
+			return nil, errSyntax
+		}
+		if _, ok := server.sets[params[0]]; !ok && server.exists(params[0]) {
+			return nil, errWrongType
+		}
+		members := make([]string, 0)
+		for member := range server.sets[params[0]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		reply := make([]interface{}, len(members))
+		for idx, member := range members {
+			reply[idx] = []byte(member)
+		}
+		return reply, nil
+	case "SISMEMBER":
+		if len(params) != 2 {
+			return nil, errSyntax
+		}
+		if server.sets[params[0]][params[1]] {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case "RPUSH", "LRANGE":
+		return server.list(cmd, params)
+	case "PEXPIREAT":
+		if len(params) != 2 {
+			return nil, errSyntax
+		}
+		at, err := strconv.ParseInt(params[1], 10, 64)
+		if err != nil {
+			return nil, errNotInt
+		}
+		if !server.exists(params[0]) {
+			return int64(0), nil
+		}
+		server.expires[params[0]] = time.Unix(0, at*int64(time.Millisecond))
+		server.touch(params[0])
+		return int64(1), nil
+	case "EVAL":
+		return server.eval(params)
+	case "ZADD", "ZREM", "ZSCORE", "ZCOUNT", "ZREVRANGE":
+		return server.zset(cmd, params)
+	}
+	return nil, Error("ERR unknown command '" + cmd + "'")
+}
+
+// CompareAndDelete is the only script supported by EVAL, it deletes the key if it keeps the value
+const CompareAndDelete = "if redis.call('get',KEYS[1])==ARGV[1] then return redis.call('del',KEYS[1]) end return 0"
+
+// eval implements EVAL script numkeys key [key ...] arg [arg ...] for the CompareAndDelete script
+func (server *Server) eval(params []string) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errSyntax
+	}
+	if params[0] != CompareAndDelete {
+		return nil, Error("ERR unsupported script")
+	}
+	if params[1] != "1" || len(params) != 4 {
+		return nil, Error("ERR wrong number of keys or arguments")
+	}
+	key := params[2]
+	server.expire(key)
+	if value, ok := server.strings[key]; ok && string(value) == params[3] {
+		server.remove(key)
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// list implements list commands: RPUSH key value [value ...] and LRANGE key start stop
+func (server *Server) list(cmd string, params []string) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errSyntax
+	}
+	key := params[0]
+	if _, ok := server.lists[key]; !ok && server.exists(key) {
+		return nil, errWrongType
+	}
+	list := server.lists[key]
+	if cmd == "RPUSH" {
+		for _, value := range params[1:] {
+			list = append(list, []byte(value))
+		}
+		server.lists[key] = list
+		server.touch(key)
+		return int64(len(list)), nil
+	}
+	if len(params) != 3 {
+		return nil, errSyntax
+	}
+	start, err := strconv.Atoi(params[1])
+	if err != nil {
+		return nil, errNotInt
+	}
+	stop, err := strconv.Atoi(params[2])
+	if err != nil {
+		return nil, errNotInt
+	}
+	if start < 0 {
+		start += len(list)
+	}
+	if stop < 0 {
+		stop += len(list)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(list) {
+		stop = len(list) - 1
+	}
+	reply := make([]interface{}, 0)
+	for idx := start; idx <= stop; idx++ {
+		reply = append(reply, append([]byte(nil), list[idx]...))
+	}
+	return reply, nil
+}
+
+// zset implements sorted set commands: ZADD key score member [score member ...], ZREM key member [member ...],
+// ZSCORE key member, ZCOUNT key min max and ZREVRANGE key start stop [WITHSCORES]
+func (server *Server) zset(cmd string, params []string) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errSyntax
+	}
+	key := params[0]
+	if _, ok := server.zsets[key]; !ok && server.exists(key) {
+		return nil, errWrongType
+	}
+	zset := server.zsets[key]
+	switch cmd {
+	case "ZADD":
+		if len(params)%2 == 0 {
+			return nil, errSyntax
+		}
+		if zset == nil {
+			zset = make(map[string]float64)
+		}
+		var count int64
+		for idx := 1; idx < len(params); idx += 2 {
+			value, err := strconv.ParseFloat(params[idx], 64)
+			if err != nil {
+				return nil, errNotFloat
+			}
+			if _, ok := zset[params[idx+1]]; !ok {
+				count++
+			}
+			zset[params[idx+1]] = value
+		}
+		server.zsets[key] = zset
+		server.touch(key)
+		return count, nil
+	case "ZREM":
+		var count int64
+		for _, member := range params[1:] {
+			if _, ok := zset[member]; ok {
+				delete(zset, member)
+				count++
+			}
+		}
+		if len(zset) == 0 {
+			server.remove(key)
+		} else if count > 0 {
+			server.touch(key)
+		}
+		return count, nil
+	case "ZSCORE":
+		if len(params) != 2 {
+			return nil, errSyntax
+		}
+		value, ok := zset[params[1]]
+		if !ok {
+			return nil, nil
+		}
+		return []byte(strconv.FormatFloat(value, 'g', -1, 64)), nil
+	case "ZCOUNT":
+		if len(params) != 3 {
+			return nil, errSyntax
+		}
+		min, minExclusive, err := parseBound(params[1])
+		if err != nil {
+			return nil, err
+		}
+		max, maxExclusive, err := parseBound(params[2])
+		if err != nil {
+			return nil, err
+		}
+		var count int64
+		for _, value := range zset {
+			if (value > min || !minExclusive && value == min) && (value < max || !maxExclusive && value == max) {
+				count++
+			}
+		}
+		return count, nil
+	}
+	// ZREVRANGE
+	if len(params) != 3 && (len(params) != 4 || strings.ToUpper(params[3]) != "WITHSCORES") {
+		return nil, errSyntax
+	}
+	start, err := strconv.Atoi(params[1])
+	if err != nil {
+		return nil, errNotInt
+	}
+	stop, err := strconv.Atoi(params[2])
+	if err != nil {
+		return nil, errNotInt
+	}
+	members := make([]string, 0, len(zset))
+	for member := range zset {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return zset[members[i]] > zset[members[j]] ||
+			zset[members[i]] == zset[members[j]] && members[i] > members[j]
+	})
+	if start < 0 {
+		start += len(members)
+	}
+	if stop < 0 {
+		stop += len(members)
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= len(members) {
+		stop = len(members) - 1
+	}
+	reply := make([]interface{}, 0)
+	for idx := start; idx <= stop; idx++ {
+		reply = append(reply, []byte(members[idx]))
+		if len(params) == 4 {
+			reply = append(reply, []byte(strconv.FormatFloat(zset[members[idx]], 'g', -1, 64)))
+		}
+	}
+	return reply, nil
+}
+
+// parseBound parses the score boundary of ZCOUNT, the boundary with ( prefix is exclusive
+func parseBound(bound string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(bound, "(")
+	value, err := strconv.ParseFloat(strings.TrimPrefix(bound, "("), 64)
+	if err != nil {
+		return 0, false, Error("ERR min or max is not a float")
+	}
+	return value, exclusive, nil
+}
+
+// set implements SET key value [NX|XX] [PX milliseconds|EX seconds]
+func (server *Server) set(params []string) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, errSyntax
+	}
+	key, value := params[0], params[1]
+	var nx, xx bool
+	var ttl time.Duration
+	for idx := 2; idx < len(params); idx++ {
+		switch strings.ToUpper(params[idx]) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "PX", "EX":
+			if idx+1 >= len(params) {
+				return nil, errSyntax
+			}
+			number, err := strconv.ParseInt(params[idx+1], 10, 64)
+			if err != nil || number <= 0 {
+				return nil, errNotInt
+			}
+			ttl = time.Duration(number) * time.Millisecond
+			if strings.ToUpper(params[idx]) == "EX" {
+				ttl = time.Duration(number) * time.Second
+			}
+			idx++
+		default:
+			return nil, errSyntax
+		}
+	}
+	if _, ok := server.strings[key]; !ok && server.exists(key) && !nx {
+		server.remove(key)
+	}
+	exists := server.exists(key)
+	if nx && exists || xx && !exists {
+		return nil, nil
+	}
+	server.strings[key] = []byte(value)
+	delete(server.expires, key)
+	if ttl > 0 {
+		server.expires[key] = time.Now().Add(ttl)
+	}
+	server.touch(key)
+	return "OK", nil
+}
+
+func (server *Server) exists(key string) bool {
+	_, isString := server.strings[key]
+	_, isSet := server.sets[key]
+	_, isZSet := server.zsets[key]
+	_, isList := server.lists[key]
+	return isString || isSet || isZSet || isList
+}
+
+// keysOf returns keys used by the command
+func (server *Server) keysOf(cmd string, params []string) []string {
+	switch cmd {
+	case "DEL", "EXISTS", "MGET":
+		return params
+	case "PING", "FLUSHDB", "FLUSHALL", "KEYS":
+		return nil
+	}
+	if len(params) > 0 {
+		return params[:1]
+	}
+	return nil
+}
+
+// match reports whether the key matches the glob style pattern with * and ? wildcards
+func match(pattern, key string) bool {
+	if pattern == "" {
+		return key == ""
+	}
+	switch pattern[0] {
+	case '*':
+		for idx := 0; idx <= len(key); idx++ {
+			if match(pattern[1:], key[idx:]) {
+				return true
+			}
+		}
+		return false
+	case '?':
+		return key != "" && match(pattern[1:], key[1:])
+	}
+	return key != "" && pattern[0] == key[0] && match(pattern[1:], key[1:])
+}
@@ -1,9 +1,14 @@
 package datastore
 
 import (
+	"context"
 	"errors"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/takama/backer"
 	"github.com/takama/backer/model"
 )
 
@@ -17,8 +22,9 @@ var (
 // Stub in-memory controller
 type Stub struct {
 	mutex       sync.RWMutex
-	tx          Transact
-	transact    transactData
+	lockMutex   sync.Mutex
+	locks       map[string]*stubTransact
+	released    chan struct{}
 	ErrReset    []error
 	ErrMigUp    []error
 	ErrMigDn    []error
@@ -30,13 +36,24 @@ type Stub struct {
 	ErrSave     []error
 	ErrDelete   []error
 	players     map[string]model.Player
+	audit       map[string][]model.AuditEntry
+	stats       map[string]model.Stats
+	lots        map[string][]model.Lot
 	tournaments map[uint64]model.Tournament
+	operations  map[string]model.Operation
+	idempotency map[string]model.Idempotency
+	scores      map[string]map[string]float64
+	expires     map[string]time.Time
 }
 
-type transactData struct {
-	mutex       sync.RWMutex
-	players     map[string]model.Player
-	tournaments map[uint64]model.Tournament
+// stubTransact keeps undo log and row locks of the in-memory transaction
+type stubTransact struct {
+	ctx        context.Context
+	stub       *Stub
+	undo       []func()
+	savepoints savepoints
+	locks      []string
+	waits      string
 }
 
 // Ready returns connection state
@@ -47,9 +64,21 @@ func (stub *Stub) Ready() bool {
 // Reset makes the DB initialization
 func (stub *Stub) Reset() error {
 	var err error
+	stub.mutex.Lock()
 	stub.players = make(map[string]model.Player)
+	stub.audit = make(map[string][]model.AuditEntry)
+	stub.stats = make(map[string]model.Stats)
+	stub.lots = make(map[string][]model.Lot)
 	stub.tournaments = make(map[uint64]model.Tournament)
-	stub.tx = stub
+	stub.operations = make(map[string]model.Operation)
+	stub.idempotency = make(map[string]model.Idempotency)
+	stub.scores = make(map[string]map[string]float64)
+	stub.expires = make(map[string]time.Time)
+	stub.mutex.Unlock()
+	stub.lockMutex.Lock()
+	stub.locks = make(map[string]*stubTransact)
+	stub.released = make(chan struct{})
+	stub.lockMutex.Unlock()
 	if len(stub.ErrReset) == 0 {
 		return nil
 	}
@@ -69,29 +98,34 @@ func (stub *Stub) MigrateDown() error {
 
 // Transaction returns DB transaction control
 func (stub *Stub) Transaction() (Transact, error) {
+	return stub.TransactionContext(context.Background())
+}
+
+// TransactionContext returns DB transaction control bound to the context
+func (stub *Stub) TransactionContext(ctx context.Context) (Transact, error) {
 	var err error
-	stub.mutex.RLock()
-	defer stub.mutex.RUnlock()
-	stub.transact.players = make(map[string]model.Player)
-	stub.transact.tournaments = make(map[uint64]model.Tournament)
-	for idx, val := range stub.players {
-		stub.transact.players[idx] = val
-	}
-	for idx, val := range stub.tournaments {
-		stub.transact.tournaments[idx] = val
+	tx := &stubTransact{ctx: ctx, stub: stub}
+	if err := ctx.Err(); err != nil {
+		return tx, err
 	}
 	if len(stub.ErrTx) == 0 {
-		return stub.tx, nil
+		return tx, nil
 	}
 	err, stub.ErrTx = stub.ErrTx[len(stub.ErrTx)-1], stub.ErrTx[:len(stub.ErrTx)-1]
-	return stub.tx, err
+	return tx, err
 }
 
-// Commit confirms all changes during a transaction
-func (stub *Stub) Commit() error {
+// Commit confirms all changes during a transaction,
+// the changes are rolled back if the transaction context is done
+func (tx *stubTransact) Commit() error {
 	var err error
-	stub.transact.players = make(map[string]model.Player)
-	stub.transact.tournaments = make(map[uint64]model.Tournament)
+	stub := tx.stub
+	if err := stub.check(tx); err != nil {
+		return err
+	}
+	tx.undo = nil
+	tx.savepoints = nil
+	stub.unlock(tx)
 	if len(stub.ErrTxCmt) == 0 {
 		return nil
 	}
@@ -100,19 +134,10 @@ func (stub *Stub) Commit() error {
 }
 
 // Rollback undo all changes during a transaction
-func (stub *Stub) Rollback() error {
+func (tx *stubTransact) Rollback() error {
 	var err error
-	stub.mutex.RLock()
-	defer stub.mutex.RUnlock()
-	stub.Reset()
-	for idx, val := range stub.transact.players {
-		stub.players[idx] = val
-	}
-	for idx, val := range stub.transact.tournaments {
-		stub.tournaments[idx] = val
-	}
-	stub.transact.players = make(map[string]model.Player)
-	stub.transact.tournaments = make(map[uint64]model.Tournament)
+	stub := tx.stub
+	tx.rollback()
 	if len(stub.ErrTxRbk) == 0 {
 		return nil
 	}
@@ -120,16 +145,155 @@ func (stub *Stub) Rollback() error {
 	return err
 }
 
+func (tx *stubTransact) rollback() {
+	tx.undoTo(0)
+	tx.savepoints = nil
+	tx.stub.unlock(tx)
+}
+
+// undoTo undo changes recorded after the mark of the transaction log
+func (tx *stubTransact) undoTo(mark int) {
+	stub := tx.stub
+	stub.mutex.Lock()
+	for idx := len(tx.undo) - 1; idx >= mark; idx-- {
+		tx.undo[idx]()
+	}
+	tx.undo = tx.undo[:mark]
+	stub.mutex.Unlock()
+}
+
+// Savepoint marks the current state of the transaction
+func (tx *stubTransact) Savepoint(name string) error {
+	if err := tx.stub.check(tx); err != nil {
+		return err
+	}
+	tx.stub.mutex.RLock()
+	defer tx.stub.mutex.RUnlock()
+	tx.savepoints = append(tx.savepoints, savepoint{name: name, mark: len(tx.undo)})
+	return nil
+}
+
+// ReleaseSavepoint destroys the savepoint keeping changes made after it
+func (tx *stubTransact) ReleaseSavepoint(name string) error {
+	if err := tx.stub.check(tx); err != nil {
+		return err
+	}
+	idx, err := tx.savepoints.find(name)
+	if err != nil {
+		return err
+	}
+	tx.savepoints = tx.savepoints[:idx]
+	return nil
+}
+
+// RollbackTo undo changes made after the savepoint
+func (tx *stubTransact) RollbackTo(name string) error {
+	if err := tx.stub.check(tx); err != nil {
+		return err
+	}
+	idx, err := tx.savepoints.find(name)
+	if err != nil {
+		return err
+	}
+	tx.undoTo(tx.savepoints[idx].mark)
+	tx.savepoints = tx.savepoints[:idx+1]
+	return nil
+}
+
+// check rolls back the transaction if its context is done
+func (stub *Stub) check(tx Transact) error {
+	transact, ok := tx.(*stubTransact)
+	if !ok {
+		return nil
+	}
+	if err := transact.ctx.Err(); err != nil {
+		transact.rollback()
+		return err
+	}
+	return nil
+}
+
+// record adds undo operation into the transaction log, must be called under the write lock
+func (stub *Stub) record(tx Transact, undo func()) {
+	if transact, ok := tx.(*stubTransact); ok {
+		transact.undo = append(transact.undo, undo)
+	}
+}
+
+// lock takes the record lock for the transaction lifetime,
+// it waits while the record is locked by another transaction
+func (stub *Stub) lock(tx Transact, key string) error {
+	transact, ok := tx.(*stubTransact)
+	if !ok {
+		return nil
+	}
+	stub.lockMutex.Lock()
+	defer stub.lockMutex.Unlock()
+	for {
+		owner, locked := stub.locks[key]
+		if !locked {
+			stub.locks[key] = transact
+			transact.locks = append(transact.locks, key)
+		}
+		if !locked || owner == transact {
+			transact.waits = ""
+			return nil
+		}
+		for steps := len(stub.locks); owner != nil && steps > 0; steps-- {
+			if owner == transact {
+				transact.waits = ""
+				return ErrDeadlock
+			}
+			if owner.waits == "" {
+				break
+			}
+			owner = stub.locks[owner.waits]
+		}
+		transact.waits = key
+		released := stub.released
+		stub.lockMutex.Unlock()
+		select {
+		case <-released:
+			stub.lockMutex.Lock()
+		case <-transact.ctx.Done():
+			stub.lockMutex.Lock()
+			transact.waits = ""
+			return transact.ctx.Err()
+		}
+	}
+}
+
+// unlock releases all locks of the transaction and wakes up waiting transactions
+func (stub *Stub) unlock(tx *stubTransact) {
+	stub.lockMutex.Lock()
+	defer stub.lockMutex.Unlock()
+	if len(tx.locks) == 0 {
+		return
+	}
+	for _, key := range tx.locks {
+		delete(stub.locks, key)
+	}
+	tx.locks = nil
+	close(stub.released)
+	stub.released = make(chan struct{})
+}
+
 // NewPlayer creates a new player with specified ID
 func (stub *Stub) NewPlayer(ID string, tx Transact) error {
 	var err error
-	stub.mutex.RLock()
-	defer stub.mutex.RUnlock()
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
 	_, ok := stub.players[ID]
 	if ok {
 		return ErrAlreadyExist
 	}
 	stub.players[ID] = model.Player{ID: ID}
+	stub.record(tx, func() {
+		delete(stub.players, ID)
+	})
 	if len(stub.ErrNew) == 0 {
 		return nil
 	}
@@ -140,12 +304,16 @@ func (stub *Stub) NewPlayer(ID string, tx Transact) error {
 // FindPlayer finds existing player by specified ID
 func (stub *Stub) FindPlayer(ID string, tx Transact) (*model.Player, error) {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
 	stub.mutex.RLock()
 	defer stub.mutex.RUnlock()
-	player, ok := stub.players[ID]
-	if !ok {
+	stored, ok := stub.players[ID]
+	if !ok || stored.Archived {
 		return nil, ErrRecordNotFound
 	}
+	player := copyPlayer(stored)
 	if len(stub.ErrFind) == 0 {
 		return &player, nil
 	}
@@ -153,13 +321,44 @@ func (stub *Stub) FindPlayer(ID string, tx Transact) (*model.Player, error) {
 	return &player, err
 }
 
+// FindPlayerForUpdate finds existing player by specified ID
+// and locks it until the end of the transaction
+func (stub *Stub) FindPlayerForUpdate(ID string, tx Transact) (*model.Player, error) {
+	if err := stub.lock(tx, "player:"+ID); err != nil {
+		stub.check(tx)
+		return nil, err
+	}
+	return stub.FindPlayer(ID, tx)
+}
+
 // SavePlayer saves a Player model
 func (stub *Stub) SavePlayer(player *model.Player, tx Transact) error {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
 	stub.mutex.Lock()
 	defer stub.mutex.Unlock()
 
-	stub.players[player.ID] = *player
+	stored, ok := stub.players[player.ID]
+	if ok && stored.Version != player.Version {
+		return &ConflictError{
+			Record:  "player",
+			ID:      player.ID,
+			Version: player.Version,
+			Stored:  stored.Version,
+		}
+	}
+	ID := player.ID
+	stub.record(tx, func() {
+		if ok {
+			stub.players[ID] = stored
+		} else {
+			delete(stub.players, ID)
+		}
+	})
+	player.Version++
+	stub.players[player.ID] = copyPlayer(*player)
 	if len(stub.ErrSave) == 0 {
 		return nil
 	}
@@ -167,13 +366,63 @@ func (stub *Stub) SavePlayer(player *model.Player, tx Transact) error {
 	return err
 }
 
-// DeletePlayer delete player by specified ID
+// ListPlayers returns a page of players which satisfy the query and the next page cursor
+func (stub *Stub) ListPlayers(query PlayerQuery, tx Transact) ([]model.Player, string, error) {
+	if err := stub.check(tx); err != nil {
+		return nil, "", err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	players := make([]model.Player, 0)
+	keys := make([]sortKey, 0)
+	for _, player := range stub.players {
+		if query.Match(&player) {
+			players = append(players, player)
+			keys = append(keys, query.key(&player))
+		}
+	}
+	order, next, err := paginate(keys, query.Page)
+	if err != nil {
+		return nil, "", err
+	}
+	page := make([]model.Player, 0, len(order))
+	for _, idx := range order {
+		page = append(page, copyPlayer(players[idx]))
+	}
+	if len(stub.ErrFind) == 0 {
+		return page, next, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return page, next, err
+}
+
+// DeletePlayer delete player by specified ID with its statistics and lots, the audit of the player is kept
 func (stub *Stub) DeletePlayer(ID string, tx Transact) error {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
 	stub.mutex.Lock()
 	defer stub.mutex.Unlock()
 
+	stored, ok := stub.players[ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	stats, hasStats := stub.stats[ID]
+	lots, hasLots := stub.lots[ID]
+	stub.record(tx, func() {
+		stub.players[ID] = stored
+		if hasStats {
+			stub.stats[ID] = stats
+		}
+		if hasLots {
+			stub.lots[ID] = lots
+		}
+	})
 	delete(stub.players, ID)
+	delete(stub.stats, ID)
+	delete(stub.lots, ID)
 	if len(stub.ErrDelete) == 0 {
 		return nil
 	}
@@ -181,16 +430,185 @@ func (stub *Stub) DeletePlayer(ID string, tx Transact) error {
 	return err
 }
 
-// NewTournament creates a new tournament with specified ID
-func (stub *Stub) NewTournament(ID uint64, tx Transact) error {
+// ArchivePlayer hides the player from Find and List methods until it is restored
+func (stub *Stub) ArchivePlayer(ID string, tx Transact) error {
+	return stub.archivePlayer(ID, true, tx)
+}
+
+// RestorePlayer returns archived player back
+func (stub *Stub) RestorePlayer(ID string, tx Transact) error {
+	return stub.archivePlayer(ID, false, tx)
+}
+
+func (stub *Stub) archivePlayer(ID string, archived bool, tx Transact) error {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+
+	stored, ok := stub.players[ID]
+	if !ok || stored.Archived == archived {
+		return ErrRecordNotFound
+	}
+	stub.record(tx, func() {
+		stub.players[ID] = stored
+	})
+	player := stored
+	player.Archived = archived
+	player.Version++
+	stub.players[ID] = player
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// NewAuditEntry adds the entry to the end of the audit of the player
+func (stub *Stub) NewAuditEntry(player string, entry *model.AuditEntry, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stored := stub.audit[player]
+	stub.record(tx, func() {
+		if len(stored) == 0 {
+			delete(stub.audit, player)
+		} else {
+			stub.audit[player] = stored
+		}
+	})
+	stub.audit[player] = append(stored[:len(stored):len(stored)], *entry)
+	if len(stub.ErrNew) == 0 {
+		return nil
+	}
+	err, stub.ErrNew = stub.ErrNew[len(stub.ErrNew)-1], stub.ErrNew[:len(stub.ErrNew)-1]
+	return err
+}
+
+// ListAudit returns the audit of the player in the order the entries were added
+func (stub *Stub) ListAudit(player string, tx Transact) ([]model.AuditEntry, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
 	stub.mutex.RLock()
 	defer stub.mutex.RUnlock()
+	audit := append(make([]model.AuditEntry, 0, len(stub.audit[player])), stub.audit[player]...)
+	if len(stub.ErrFind) == 0 {
+		return audit, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return audit, err
+}
+
+// FindStats finds statistics of the player
+func (stub *Stub) FindStats(player string, tx Transact) (*model.Stats, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	stats, ok := stub.stats[player]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	if len(stub.ErrFind) == 0 {
+		return &stats, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return &stats, err
+}
+
+// SaveStats saves statistics of the player
+func (stub *Stub) SaveStats(player string, stats *model.Stats, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stored, ok := stub.stats[player]
+	stub.record(tx, func() {
+		if ok {
+			stub.stats[player] = stored
+		} else {
+			delete(stub.stats, player)
+		}
+	})
+	stub.stats[player] = *stats
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// FindLots finds lots of the player, the player without lots has an empty list
+func (stub *Stub) FindLots(player string, tx Transact) ([]model.Lot, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	lots := append(make([]model.Lot, 0, len(stub.lots[player])), stub.lots[player]...)
+	if len(stub.ErrFind) == 0 {
+		return lots, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return lots, err
+}
+
+// SaveLots replaces lots of the player, empty lots are removed
+func (stub *Stub) SaveLots(player string, lots []model.Lot, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stored, ok := stub.lots[player]
+	stub.record(tx, func() {
+		if ok {
+			stub.lots[player] = stored
+		} else {
+			delete(stub.lots, player)
+		}
+	})
+	if len(lots) == 0 {
+		delete(stub.lots, player)
+	} else {
+		stub.lots[player] = append([]model.Lot(nil), lots...)
+	}
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// NewTournament creates a new tournament with specified ID
+func (stub *Stub) NewTournament(ID uint64, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
 	_, ok := stub.tournaments[ID]
 	if ok {
 		return ErrAlreadyExist
 	}
-	stub.tournaments[ID] = model.Tournament{ID: ID, Bidders: make([]model.Bidder, 0)}
+	stub.tournaments[ID] = model.Tournament{ID: ID, Bidders: make([]model.Bidder, 0), Created: time.Now()}
+	stub.record(tx, func() {
+		delete(stub.tournaments, ID)
+	})
 	if len(stub.ErrNew) == 0 {
 		return nil
 	}
@@ -201,12 +619,16 @@ func (stub *Stub) NewTournament(ID uint64, tx Transact) error {
 // FindTournament finds existing tournament by specified ID
 func (stub *Stub) FindTournament(ID uint64, tx Transact) (*model.Tournament, error) {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
 	stub.mutex.RLock()
 	defer stub.mutex.RUnlock()
-	tournament, ok := stub.tournaments[ID]
-	if !ok {
+	stored, ok := stub.tournaments[ID]
+	if !ok || stored.Archived {
 		return nil, ErrRecordNotFound
 	}
+	tournament := copyTournament(stored)
 	if len(stub.ErrFind) == 0 {
 		return &tournament, nil
 	}
@@ -214,13 +636,44 @@ func (stub *Stub) FindTournament(ID uint64, tx Transact) (*model.Tournament, err
 	return &tournament, err
 }
 
+// FindTournamentForUpdate finds existing tournament by specified ID
+// and locks it until the end of the transaction
+func (stub *Stub) FindTournamentForUpdate(ID uint64, tx Transact) (*model.Tournament, error) {
+	if err := stub.lock(tx, "tournament:"+strconv.FormatUint(ID, 10)); err != nil {
+		stub.check(tx)
+		return nil, err
+	}
+	return stub.FindTournament(ID, tx)
+}
+
 // SaveTournament saves a Tournament model
 func (stub *Stub) SaveTournament(tournament *model.Tournament, tx Transact) error {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
 	stub.mutex.Lock()
 	defer stub.mutex.Unlock()
 
-	stub.tournaments[tournament.ID] = *tournament
+	stored, ok := stub.tournaments[tournament.ID]
+	if ok && stored.Version != tournament.Version {
+		return &ConflictError{
+			Record:  "tournament",
+			ID:      strconv.FormatUint(tournament.ID, 10),
+			Version: tournament.Version,
+			Stored:  stored.Version,
+		}
+	}
+	ID := tournament.ID
+	stub.record(tx, func() {
+		if ok {
+			stub.tournaments[ID] = stored
+		} else {
+			delete(stub.tournaments, ID)
+		}
+	})
+	tournament.Version++
+	stub.tournaments[tournament.ID] = copyTournament(*tournament)
 	if len(stub.ErrSave) == 0 {
 		return nil
 	}
@@ -228,12 +681,52 @@ func (stub *Stub) SaveTournament(tournament *model.Tournament, tx Transact) erro
 	return err
 }
 
+// ListTournaments returns a page of tournaments which satisfy the query and the next page cursor
+func (stub *Stub) ListTournaments(query TournamentQuery, tx Transact) ([]model.Tournament, string, error) {
+	if err := stub.check(tx); err != nil {
+		return nil, "", err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	tournaments := make([]model.Tournament, 0)
+	keys := make([]sortKey, 0)
+	for _, tournament := range stub.tournaments {
+		if query.Match(&tournament) {
+			tournaments = append(tournaments, tournament)
+			keys = append(keys, query.key(&tournament))
+		}
+	}
+	order, next, err := paginate(keys, query.Page)
+	if err != nil {
+		return nil, "", err
+	}
+	page := make([]model.Tournament, 0, len(order))
+	for _, idx := range order {
+		page = append(page, copyTournament(tournaments[idx]))
+	}
+	if len(stub.ErrFind) == 0 {
+		return page, next, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return page, next, err
+}
+
 // DeleteTournament delete tournament by specified ID
 func (stub *Stub) DeleteTournament(ID uint64, tx Transact) error {
 	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
 	stub.mutex.Lock()
 	defer stub.mutex.Unlock()
 
+	stored, ok := stub.tournaments[ID]
+	if !ok {
+		return ErrRecordNotFound
+	}
+	stub.record(tx, func() {
+		stub.tournaments[ID] = stored
+	})
 	delete(stub.tournaments, ID)
 	if len(stub.ErrDelete) == 0 {
 		return nil
@@ -241,3 +734,398 @@ func (stub *Stub) DeleteTournament(ID uint64, tx Transact) error {
 	err, stub.ErrDelete = stub.ErrDelete[len(stub.ErrDelete)-1], stub.ErrDelete[:len(stub.ErrDelete)-1]
 	return err
 }
+
+// ArchiveTournament hides the tournament from Find and List methods until it is restored
+func (stub *Stub) ArchiveTournament(ID uint64, tx Transact) error {
+	return stub.archiveTournament(ID, true, tx)
+}
+
+// RestoreTournament returns archived tournament back
+func (stub *Stub) RestoreTournament(ID uint64, tx Transact) error {
+	return stub.archiveTournament(ID, false, tx)
+}
+
+func (stub *Stub) archiveTournament(ID uint64, archived bool, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+
+	stored, ok := stub.tournaments[ID]
+	if !ok || stored.Archived == archived {
+		return ErrRecordNotFound
+	}
+	stub.record(tx, func() {
+		stub.tournaments[ID] = stored
+	})
+	tournament := copyTournament(stored)
+	tournament.Archived = archived
+	tournament.Version++
+	stub.tournaments[ID] = tournament
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// NewOperation creates a new ledger operation
+func (stub *Stub) NewOperation(operation *model.Operation, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	ID := operation.ID
+	if _, ok := stub.operations[ID]; ok {
+		return ErrAlreadyExist
+	}
+	stub.operations[ID] = copyOperation(*operation)
+	stub.record(tx, func() {
+		delete(stub.operations, ID)
+	})
+	if len(stub.ErrNew) == 0 {
+		return nil
+	}
+	err, stub.ErrNew = stub.ErrNew[len(stub.ErrNew)-1], stub.ErrNew[:len(stub.ErrNew)-1]
+	return err
+}
+
+// FindOperation finds existing ledger operation by specified ID
+func (stub *Stub) FindOperation(ID string, tx Transact) (*model.Operation, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	stored, ok := stub.operations[ID]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	operation := copyOperation(stored)
+	if len(stub.ErrFind) == 0 {
+		return &operation, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return &operation, err
+}
+
+// ListOperations returns a page of ledger operations which satisfy the query and the next page cursor
+func (stub *Stub) ListOperations(query OperationQuery, tx Transact) ([]model.Operation, string, error) {
+	if err := stub.check(tx); err != nil {
+		return nil, "", err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	operations := make([]model.Operation, 0)
+	keys := make([]sortKey, 0)
+	for _, operation := range stub.operations {
+		if query.Match(&operation) {
+			operations = append(operations, copyOperation(operation))
+			keys = append(keys, query.key(&operation))
+		}
+	}
+	order, next, err := paginate(keys, query.Page)
+	if err != nil {
+		return nil, "", err
+	}
+	page := make([]model.Operation, 0, len(order))
+	for _, idx := range order {
+		page = append(page, operations[idx])
+	}
+	if len(stub.ErrFind) == 0 {
+		return page, next, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return page, next, err
+}
+
+// NewIdempotency keeps the outcome of the request done with the idempotency key,
+// the record replaces an existing one which is out of the retention window
+func (stub *Stub) NewIdempotency(record *model.Idempotency, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	key := record.Key
+	stored, ok := stub.idempotency[key]
+	if ok && !expired(&stored) {
+		return ErrAlreadyExist
+	}
+	stub.idempotency[key] = *record
+	stub.record(tx, func() {
+		if ok {
+			stub.idempotency[key] = stored
+		} else {
+			delete(stub.idempotency, key)
+		}
+	})
+	if len(stub.ErrNew) == 0 {
+		return nil
+	}
+	err, stub.ErrNew = stub.ErrNew[len(stub.ErrNew)-1], stub.ErrNew[:len(stub.ErrNew)-1]
+	return err
+}
+
+// FindIdempotency finds the outcome of the request done with the idempotency key within the retention window
+func (stub *Stub) FindIdempotency(key string, tx Transact) (*model.Idempotency, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	record, ok := stub.idempotency[key]
+	if !ok || expired(&record) {
+		return nil, ErrRecordNotFound
+	}
+	if len(stub.ErrFind) == 0 {
+		return &record, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return &record, err
+}
+
+// SaveScore sets the value of the member in the board
+func (stub *Stub) SaveScore(board, member string, value float64, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stub.setScore(board, member, value, true, tx)
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// FindScore finds the value and the rank of the member in the board
+func (stub *Stub) FindScore(board, member string, tx Transact) (*model.Score, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	value, ok := stub.board(board)[member]
+	if !ok {
+		return nil, ErrRecordNotFound
+	}
+	score := &model.Score{Board: board, Member: member, Value: value, Rank: 1}
+	for _, other := range stub.board(board) {
+		if other > value {
+			score.Rank++
+		}
+	}
+	if len(stub.ErrFind) == 0 {
+		return score, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return score, err
+}
+
+// FindScoreValue finds the value of the member in the board
+func (stub *Stub) FindScoreValue(board, member string, tx Transact) (float64, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return 0, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	value, ok := stub.board(board)[member]
+	if !ok {
+		return 0, ErrRecordNotFound
+	}
+	if len(stub.ErrFind) == 0 {
+		return value, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return value, err
+}
+
+// ListScores returns up to count scores of the board with the highest values,
+// members with equal values are ordered in reverse order of their names as Redis does,
+// negative count lists all scores
+func (stub *Stub) ListScores(board string, count int, tx Transact) ([]model.Score, error) {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return nil, err
+	}
+	stub.mutex.RLock()
+	defer stub.mutex.RUnlock()
+	scores := make([]model.Score, 0, len(stub.board(board)))
+	for member, value := range stub.board(board) {
+		scores = append(scores, model.Score{Board: board, Member: member, Value: value})
+	}
+	sort.Slice(scores, func(i, j int) bool {
+		return scores[i].Value > scores[j].Value ||
+			scores[i].Value == scores[j].Value && scores[i].Member > scores[j].Member
+	})
+	if count >= 0 && count < len(scores) {
+		scores = scores[:count]
+	}
+	rank(scores)
+	if len(stub.ErrFind) == 0 {
+		return scores, nil
+	}
+	err, stub.ErrFind = stub.ErrFind[len(stub.ErrFind)-1], stub.ErrFind[:len(stub.ErrFind)-1]
+	return scores, err
+}
+
+// DeleteScore removes the member from the board
+func (stub *Stub) DeleteScore(board, member string, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	stub.setScore(board, member, 0, false, tx)
+	if len(stub.ErrDelete) == 0 {
+		return nil
+	}
+	err, stub.ErrDelete = stub.ErrDelete[len(stub.ErrDelete)-1], stub.ErrDelete[:len(stub.ErrDelete)-1]
+	return err
+}
+
+// ExpireBoard sets the moment when the board is removed with all its scores,
+// the board which has no scores is not changed
+func (stub *Stub) ExpireBoard(board string, at time.Time, tx Transact) error {
+	var err error
+	if err = stub.check(tx); err != nil {
+		return err
+	}
+	stub.mutex.Lock()
+	defer stub.mutex.Unlock()
+	if len(stub.board(board)) > 0 {
+		stub.setExpiry(board, at, true, tx)
+	}
+	if len(stub.ErrSave) == 0 {
+		return nil
+	}
+	err, stub.ErrSave = stub.ErrSave[len(stub.ErrSave)-1], stub.ErrSave[:len(stub.ErrSave)-1]
+	return err
+}
+
+// board returns scores of the board or nil if the board is expired, must be called under the lock
+func (stub *Stub) board(board string) map[string]float64 {
+	if at, ok := stub.expires[board]; ok && !time.Now().Before(at) {
+		return nil
+	}
+	return stub.scores[board]
+}
+
+// setExpiry sets or removes the expiry of the board recording the undo operation,
+// must be called under the write lock
+func (stub *Stub) setExpiry(board string, at time.Time, keep bool, tx Transact) {
+	stored, ok := stub.expires[board]
+	stub.record(tx, func() {
+		stub.putExpiry(board, stored, ok)
+	})
+	stub.putExpiry(board, at, keep)
+}
+
+// putExpiry sets the expiry of the board or removes it if keep is not set
+func (stub *Stub) putExpiry(board string, at time.Time, keep bool) {
+	if keep {
+		stub.expires[board] = at
+	} else {
+		delete(stub.expires, board)
+	}
+}
+
+// setScore sets or removes the member of the board recording the undo operation,
+// the expired board is removed before and the expiry of the removed board is cleared as Redis does,
+// must be called under the write lock
+func (stub *Stub) setScore(board, member string, value float64, keep bool, tx Transact) {
+	if at, ok := stub.expires[board]; ok && !time.Now().Before(at) {
+		scores := stub.scores[board]
+		stub.record(tx, func() {
+			stub.scores[board] = scores
+			stub.expires[board] = at
+		})
+		delete(stub.scores, board)
+		delete(stub.expires, board)
+	}
+	stored, ok := stub.scores[board][member]
+	stub.record(tx, func() {
+		stub.putScore(board, member, stored, ok)
+	})
+	stub.putScore(board, member, value, keep)
+	if _, ok := stub.expires[board]; ok && len(stub.scores[board]) == 0 {
+		stub.setExpiry(board, time.Time{}, false, tx)
+	}
+}
+
+// putScore sets the value of the member or removes it if keep is not set
+func (stub *Stub) putScore(board, member string, value float64, keep bool) {
+	if !keep {
+		delete(stub.scores[board], member)
+		if len(stub.scores[board]) == 0 {
+			delete(stub.scores, board)
+		}
+		return
+	}
+	if stub.scores[board] == nil {
+		stub.scores[board] = make(map[string]float64)
+	}
+	stub.scores[board][member] = value
+}
+
+// copyPlayer makes a deep copy of the player to avoid sharing of its nested records
+func copyPlayer(player model.Player) model.Player {
+	player.Holds = append([]model.Hold(nil), player.Holds...)
+	if player.Limits != nil {
+		limits := *player.Limits
+		player.Limits = &limits
+	}
+	if player.PendingLimits != nil {
+		pending := *player.PendingLimits
+		player.PendingLimits = &pending
+	}
+	if player.Exclusion != nil {
+		exclusion := *player.Exclusion
+		player.Exclusion = &exclusion
+	}
+	if player.Wallets != nil {
+		wallets := make(map[string]backer.Points, len(player.Wallets))
+		for currency, balance := range player.Wallets {
+			wallets[currency] = balance
+		}
+		player.Wallets = wallets
+	}
+	return player
+}
+
+// copyOperation makes a deep copy of the operation to avoid sharing of postings
+func copyOperation(operation model.Operation) model.Operation {
+	operation.Postings = append([]model.Posting(nil), operation.Postings...)
+	return operation
+}
+
+// copyTournament makes a deep copy of the tournament to avoid sharing of bidders and rules
+func copyTournament(tournament model.Tournament) model.Tournament {
+	bidders := make([]model.Bidder, len(tournament.Bidders))
+	for idx, bidder := range tournament.Bidders {
+		backers := make([]string, len(bidder.Backers))
+		copy(backers, bidder.Backers)
+		bidder.Backers = backers
+		bidders[idx] = bidder
+	}
+	tournament.Bidders = bidders
+	if tournament.BackerRules != nil {
+		rules := *tournament.BackerRules
+		rules.Blocklist = append([][2]string(nil), rules.Blocklist...)
+		tournament.BackerRules = &rules
+	}
+	return tournament
+}
@@ -0,0 +1,100 @@
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStubRollback(t *testing.T) {
+	testRollback(t, new(Stub))
+}
+
+func TestStubListPlayers(t *testing.T) {
+	testListPlayers(t, new(Stub))
+}
+
+func TestStubListTournaments(t *testing.T) {
+	testListTournaments(t, new(Stub))
+}
+
+func TestStubArchive(t *testing.T) {
+	testArchive(t, new(Stub))
+}
+
+func TestStubCancel(t *testing.T) {
+	testCancel(t, new(Stub))
+}
+
+func TestStubWithTransaction(t *testing.T) {
+	testWithTransaction(t, new(Stub))
+}
+
+func TestStubSavepoints(t *testing.T) {
+	testSavepoints(t, new(Stub))
+}
+
+func TestStubOperations(t *testing.T) {
+	testOperations(t, new(Stub))
+}
+
+func TestStubIdempotency(t *testing.T) {
+	testIdempotency(t, new(Stub))
+}
+
+func TestStubScores(t *testing.T) {
+	testScores(t, new(Stub))
+}
+
+func TestStubPlayerRecords(t *testing.T) {
+	testPlayerRecords(t, new(Stub))
+}
+
+func TestStubLocks(t *testing.T) {
+
+	store := new(Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewPlayer("p2", nil)
+
+	tx1, _ := store.Transaction()
+	tx2, _ := store.Transaction()
+	_, err := store.FindPlayerForUpdate("p1", tx1)
+	test(t, err == nil, "Expected lock the player, got", err)
+	_, err = store.FindPlayer("p1", tx2)
+	test(t, err == nil, "Expected find the locked player without lock, got", err)
+
+	locked := make(chan error)
+	go func() {
+		_, err := store.FindPlayerForUpdate("p1", tx2)
+		locked <- err
+	}()
+	select {
+	case err = <-locked:
+		t.Error("Expected waiting for the lock, got", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	err = tx1.Commit()
+	test(t, err == nil, "Expected commit, got", err)
+	select {
+	case err = <-locked:
+		test(t, err == nil, "Expected lock the player, got", err)
+	case <-time.After(time.Second):
+		t.Fatal("Expected lock after commit, got timeout")
+	}
+
+	tx1, _ = store.Transaction()
+	_, err = store.FindPlayerForUpdate("p2", tx1)
+	test(t, err == nil, "Expected lock the player, got", err)
+	go func() {
+		_, err := store.FindPlayerForUpdate("p2", tx2)
+		locked <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	_, err = store.FindPlayerForUpdate("p1", tx1)
+	test(t, err == ErrDeadlock, "Expected", ErrDeadlock, "got", err)
+	test(t, IsRetryable(err), "Expected retryable error, got", err)
+	tx1.Rollback()
+	err = <-locked
+	test(t, err == nil, "Expected lock the player, got", err)
+	tx2.Commit()
+}
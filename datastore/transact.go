@@ -1,7 +1,98 @@
 package datastore
 
-// Transact contains transaction control methods
+import (
+	"context"
+	"errors"
+)
+
+var (
+	// ErrSavepointNotFound appears if the savepoint was not created in the transaction
+	// or it was already released
+	ErrSavepointNotFound = errors.New("Savepoint not found")
+)
+
+// Transact contains transaction control methods.
+// Savepoints follow SQL semantics: the latest savepoint is used if the name is repeated,
+// rolling back to a savepoint keeps it and destroys savepoints created after it,
+// releasing a savepoint keeps its changes and destroys it with savepoints created after it.
+// Locks taken after a savepoint are kept until the end of the transaction
 type Transact interface {
 	Commit() error
 	Rollback() error
+	Savepoint(name string) error
+	ReleaseSavepoint(name string) error
+	RollbackTo(name string) error
+}
+
+// WithTransaction runs fn within a new transaction of the controller,
+// see WithTransactionContext
+func WithTransaction(ctrl Controller, fn func(tx Transact) error) error {
+	return WithTransactionContext(context.Background(), ctrl, fn)
+}
+
+// WithTransactionContext runs fn within a new transaction bound to the context,
+// the transaction is committed if fn succeeds and rolled back if fn returns an error or panics,
+// the whole unit of work is repeated up to MaxRetries times if it fails with a retryable error,
+// so fn should not change anything outside of the transaction
+func WithTransactionContext(ctx context.Context, ctrl Controller, fn func(tx Transact) error) error {
+	for attempt := 0; ; attempt++ {
+		err := transact(ctx, ctrl, fn)
+		if !IsRetryable(err) || attempt >= MaxRetries {
+			return err
+		}
+	}
+}
+
+func transact(ctx context.Context, ctrl Controller, fn func(tx Transact) error) error {
+	tx, err := ctrl.TransactionContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// WithSavepoint runs fn within a savepoint of the transaction,
+// the changes of fn are rolled back to the savepoint if it returns an error
+// and the transaction could be continued
+func WithSavepoint(tx Transact, name string, fn func() error) error {
+	if err := tx.Savepoint(name); err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rbkErr := tx.RollbackTo(name); rbkErr != nil {
+			return rbkErr
+		}
+		tx.ReleaseSavepoint(name)
+		return err
+	}
+	return tx.ReleaseSavepoint(name)
+}
+
+// savepoint keeps position of the transaction log where the savepoint was created
+type savepoint struct {
+	name string
+	mark int
+}
+
+// savepoints is a stack of the transaction savepoints
+type savepoints []savepoint
+
+// find returns index of the latest savepoint with the name
+func (stack savepoints) find(name string) (int, error) {
+	for idx := len(stack) - 1; idx >= 0; idx-- {
+		if stack[idx].name == name {
+			return idx, nil
+		}
+	}
+	return 0, ErrSavepointNotFound
 }
@@ -0,0 +1,294 @@
+// Package fraud analyzes tournaments and their bidder/backer graphs
+// to flag suspicious patterns of collusion between the players
+package fraud
+
+import (
+	"sort"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Patterns of the findings
+const (
+	// Ring is a closed group of players who repeatedly back each other
+	Ring = "ring"
+	// WinnerBacking is a backer who almost always backs the eventual winner
+	WinnerBacking = "winner backing"
+	// ChipDumping is a pair of bidders where one of them repeatedly loses to the other one
+	ChipDumping = "chip dumping"
+)
+
+// Options defines thresholds of the analysis
+type Options struct {
+	// MinOccurrences is the minimal number of the repeated backings or losses to flag the players
+	MinOccurrences int
+	// WinRate is the minimal share of backed winners to flag the backer
+	WinRate float64
+}
+
+// DefaultOptions defines thresholds of the analysis which are used if options are not set
+var DefaultOptions = Options{MinOccurrences: 3, WinRate: 0.8}
+
+// Finding describes the suspicious pattern of the players, the score from 0 to 1 shows how strong it is
+// and the tournaments are the evidence of the pattern
+type Finding struct {
+	Pattern     string
+	Players     []string
+	Score       float64
+	Tournaments []uint64
+}
+
+// Report contains findings of the analysis ordered by score
+type Report struct {
+	Tournaments int
+	Findings    []Finding
+}
+
+// Analyze scans all tournaments including archived ones and reports suspicious patterns,
+// it reads the records without a transaction and could run offline against any Controller
+func Analyze(ctrl datastore.Controller, options Options) (*Report, error) {
+	if options.MinOccurrences <= 0 {
+		options.MinOccurrences = DefaultOptions.MinOccurrences
+	}
+	if options.WinRate <= 0 {
+		options.WinRate = DefaultOptions.WinRate
+	}
+	tournaments := make([]model.Tournament, 0)
+	for _, archived := range []bool{false, true} {
+		query := datastore.TournamentQuery{Archived: archived}
+		for {
+			page, next, err := ctrl.ListTournaments(query, nil)
+			if err != nil {
+				return nil, err
+			}
+			tournaments = append(tournaments, page...)
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+	}
+	sort.Slice(tournaments, func(i, j int) bool { return tournaments[i].ID < tournaments[j].ID })
+
+	report := &Report{Tournaments: len(tournaments)}
+	report.Findings = append(report.Findings, rings(tournaments, options)...)
+	report.Findings = append(report.Findings, winnerBacking(tournaments, options)...)
+	report.Findings = append(report.Findings, chipDumping(tournaments, options)...)
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		return report.Findings[i].Score > report.Findings[j].Score
+	})
+	return report, nil
+}
+
+// edge is a directed pair of players which keys tournaments of their relation,
+// e.g. a backer and the backed bidder or a loser and the winner
+type edge struct {
+	from, to string
+}
+
+// rings finds strongly connected groups of players in the graph of repeated backings
+func rings(tournaments []model.Tournament, options Options) []Finding {
+	backings := make(map[edge][]uint64)
+	total := make(map[string]int)
+	for _, tournament := range tournaments {
+		for _, bidder := range tournament.Bidders {
+			for _, backer := range bidder.Backers {
+				link := edge{from: backer, to: bidder.ID}
+				backings[link] = append(backings[link], tournament.ID)
+				total[backer]++
+			}
+		}
+	}
+	graph := make(map[string][]string)
+	for link, evidence := range backings {
+		if len(evidence) >= options.MinOccurrences {
+			graph[link.from] = append(graph[link.from], link.to)
+		}
+	}
+	for node := range graph {
+		sort.Strings(graph[node])
+	}
+	findings := make([]Finding, 0)
+	for _, component := range components(graph) {
+		if len(component) < 2 {
+			continue
+		}
+		members := make(map[string]bool, len(component))
+		for _, player := range component {
+			members[player] = true
+		}
+		internal, all := 0, 0
+		evidence := make([]uint64, 0)
+		for link, tournaments := range backings {
+			if members[link.from] && members[link.to] {
+				internal += len(tournaments)
+				evidence = append(evidence, tournaments...)
+			}
+		}
+		for _, player := range component {
+			all += total[player]
+		}
+		findings = append(findings, Finding{
+			Pattern:     Ring,
+			Players:     component,
+			Score:       float64(internal) / float64(all),
+			Tournaments: unique(evidence),
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool { return findings[i].Players[0] < findings[j].Players[0] })
+	return findings
+}
+
+// winnerBacking finds backers who back winners of the finished tournaments more often than the win rate
+func winnerBacking(tournaments []model.Tournament, options Options) []Finding {
+	backed := make(map[string]int)
+	won := make(map[string][]uint64)
+	for _, tournament := range tournaments {
+		if !tournament.IsFinished {
+			continue
+		}
+		for _, bidder := range tournament.Bidders {
+			for _, backer := range bidder.Backers {
+				backed[backer]++
+				if bidder.Winner {
+					won[backer] = append(won[backer], tournament.ID)
+				}
+			}
+		}
+	}
+	findings := make([]Finding, 0)
+	for _, backer := range sortedKeys(backed) {
+		rate := float64(len(won[backer])) / float64(backed[backer])
+		if len(won[backer]) >= options.MinOccurrences && rate >= options.WinRate {
+			findings = append(findings, Finding{
+				Pattern:     WinnerBacking,
+				Players:     []string{backer},
+				Score:       rate,
+				Tournaments: unique(won[backer]),
+			})
+		}
+	}
+	return findings
+}
+
+// chipDumping finds pairs of bidders where one of them loses to the other one in the shared tournaments
+// and never wins against it
+func chipDumping(tournaments []model.Tournament, options Options) []Finding {
+	shared := make(map[edge]int)
+	losses := make(map[edge][]uint64)
+	for _, tournament := range tournaments {
+		if !tournament.IsFinished {
+			continue
+		}
+		for _, loser := range tournament.Bidders {
+			for _, winner := range tournament.Bidders {
+				if loser.ID >= winner.ID {
+					continue
+				}
+				shared[edge{from: loser.ID, to: winner.ID}]++
+			}
+			if loser.Winner {
+				continue
+			}
+			for _, winner := range tournament.Bidders {
+				if winner.Winner {
+					link := edge{from: loser.ID, to: winner.ID}
+					losses[link] = append(losses[link], tournament.ID)
+				}
+			}
+		}
+	}
+	findings := make([]Finding, 0)
+	for link, evidence := range losses {
+		if len(evidence) < options.MinOccurrences || len(losses[edge{from: link.to, to: link.from}]) > 0 {
+			continue
+		}
+		pair := edge{from: link.from, to: link.to}
+		if pair.from > pair.to {
+			pair = edge{from: link.to, to: link.from}
+		}
+		findings = append(findings, Finding{
+			Pattern:     ChipDumping,
+			Players:     []string{link.from, link.to},
+			Score:       float64(len(evidence)) / float64(shared[pair]),
+			Tournaments: unique(evidence),
+		})
+	}
+	sort.Slice(findings, func(i, j int) bool {
+		return findings[i].Players[0] < findings[j].Players[0] ||
+			findings[i].Players[0] == findings[j].Players[0] && findings[i].Players[1] < findings[j].Players[1]
+	})
+	return findings
+}
+
+// components returns strongly connected components of the graph with sorted players (Tarjan's algorithm)
+func components(graph map[string][]string) [][]string {
+	index := make(map[string]int)
+	low := make(map[string]int)
+	onStack := make(map[string]bool)
+	stack := make([]string, 0)
+	result := make([][]string, 0)
+	var visit func(node string)
+	visit = func(node string) {
+		index[node] = len(index)
+		low[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+		for _, next := range graph[node] {
+			if _, ok := index[next]; !ok {
+				visit(next)
+				if low[next] < low[node] {
+					low[node] = low[next]
+				}
+			} else if onStack[next] && index[next] < low[node] {
+				low[node] = index[next]
+			}
+		}
+		if low[node] == index[node] {
+			component := make([]string, 0)
+			for {
+				last := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[last] = false
+				component = append(component, last)
+				if last == node {
+					break
+				}
+			}
+			sort.Strings(component)
+			result = append(result, component)
+		}
+	}
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+	for _, node := range nodes {
+		if _, ok := index[node]; !ok {
+			visit(node)
+		}
+	}
+	return result
+}
+
+func sortedKeys(counts map[string]int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func unique(tournaments []uint64) []uint64 {
+	sort.Slice(tournaments, func(i, j int) bool { return tournaments[i] < tournaments[j] })
+	result := make([]uint64, 0, len(tournaments))
+	for idx, ID := range tournaments {
+		if idx == 0 || tournaments[idx-1] != ID {
+			result = append(result, ID)
+		}
+	}
+	return result
+}
@@ -0,0 +1,93 @@
+package fraud
+
+import (
+	"testing"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func save(t *testing.T, store *datastore.Stub, ID uint64, finished bool, bidders ...model.Bidder) {
+	err := store.NewTournament(ID, nil)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	tournament, err := store.FindTournament(ID, nil)
+	test(t, err == nil, "Expected find the tournament, got", err)
+	tournament.IsFinished = finished
+	tournament.Bidders = bidders
+	err = store.SaveTournament(tournament, nil)
+	test(t, err == nil, "Expected save the tournament, got", err)
+}
+
+func TestAnalyze(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	// p1 and p2 back each other and b1 always backs the winner, p3 always loses to p4
+	for ID := uint64(1); ID <= 3; ID++ {
+		save(t, store, ID, true,
+			model.Bidder{ID: "p1", Winner: true, Backers: []string{"p2", "b1"}},
+			model.Bidder{ID: "p3"},
+			model.Bidder{ID: "p4", Backers: []string{"b2"}},
+		)
+	}
+	for ID := uint64(4); ID <= 6; ID++ {
+		save(t, store, ID, ID != 6,
+			model.Bidder{ID: "p2", Winner: true, Backers: []string{"p1", "b1"}},
+			model.Bidder{ID: "p4", Winner: ID == 5},
+			model.Bidder{ID: "p3", Backers: []string{"b2"}},
+		)
+	}
+	save(t, store, 7, true,
+		model.Bidder{ID: "p5", Winner: true},
+		model.Bidder{ID: "p6", Backers: []string{"b1"}},
+	)
+	err := store.ArchiveTournament(7, nil)
+	test(t, err == nil, "Expected archive of the tournament, got", err)
+
+	report, err := Analyze(store, Options{})
+	test(t, err == nil, "Expected analysis of the tournaments, got", err)
+	test(t, report.Tournaments == 7, "Expected 7 tournaments, got", report.Tournaments)
+	findings := make(map[string][]Finding)
+	for _, finding := range report.Findings {
+		findings[finding.Pattern] = append(findings[finding.Pattern], finding)
+	}
+	for idx := 1; idx < len(report.Findings); idx++ {
+		test(t, report.Findings[idx-1].Score >= report.Findings[idx].Score, "Expected findings ordered by score")
+	}
+
+	rings := findings[Ring]
+	test(t, len(rings) == 1 && len(rings[0].Players) == 2 &&
+		rings[0].Players[0] == "p1" && rings[0].Players[1] == "p2", "Expected ring of p1 and p2, got", rings)
+	if len(rings) == 1 {
+		test(t, rings[0].Score == 1 && len(rings[0].Tournaments) == 6, "Expected full ring score, got", rings[0])
+	}
+
+	// findings are ordered by score: p2 backs p1 only when p1 wins, b1 backs the winners in 5 of 6 tournaments
+	backers := findings[WinnerBacking]
+	test(t, len(backers) == 2, "Expected 2 backers of winners, got", backers)
+	if len(backers) == 2 {
+		test(t, backers[1].Players[0] == "b1" && backers[1].Score == 5.0/6.0 && len(backers[1].Tournaments) == 5,
+			"Expected 5 of 6 backed winners, got", backers[1])
+		test(t, backers[0].Players[0] == "p2" && backers[0].Score == 1 && len(backers[0].Tournaments) == 3,
+			"Expected 3 of 3 backed winners, got", backers[0])
+	}
+
+	dumping := findings[ChipDumping]
+	test(t, len(dumping) == 2, "Expected 2 chip dumping pairs, got", dumping)
+	for _, finding := range dumping {
+		test(t, finding.Players[1] == "p1" || finding.Players[1] == "p2",
+			"Expected losses to the ring members, got", finding)
+		test(t, finding.Players[0] != "p4" || finding.Players[1] != "p2",
+			"Expected no chip dumping of p4 which won against p2, got", finding)
+	}
+
+	report, err = Analyze(store, Options{MinOccurrences: 10})
+	test(t, err == nil, "Expected analysis of the tournaments, got", err)
+	test(t, len(report.Findings) == 0, "Expected no findings, got", report.Findings)
+}
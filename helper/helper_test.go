@@ -6,7 +6,7 @@ import (
 
 func test(t *testing.T, expected bool, messages ...interface{}) {
 	if !expected {
-		t.Error(messages)
+		t.Error(messages...)
 	}
 }
 
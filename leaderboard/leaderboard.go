@@ -0,0 +1,257 @@
+// Package leaderboard ranks the players across tournaments by winnings, ROI, tournaments won
+// and backers' profit within the periods, the leaderboards are kept as scores of the datastore boards
+// and updated when results of the tournaments are set or reversed
+package leaderboard
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/helper"
+	"github.com/takama/backer/model"
+	"github.com/takama/backer/stats"
+)
+
+var (
+	// ErrUnknownMetric appears if the leaderboard metric is not supported
+	ErrUnknownMetric = errors.New("Unknown leaderboard metric")
+	// ErrUnknownPeriod appears if the leaderboard period is not supported
+	ErrUnknownPeriod = errors.New("Unknown leaderboard period")
+)
+
+// Metrics of the leaderboards
+const (
+	// Winnings ranks the players by prize points received as bidders and as backers
+	Winnings = "winnings"
+	// ROI ranks the players by returned points minus staked points divided by staked points
+	ROI = "roi"
+	// Wins ranks the players by the number of tournaments won as bidders
+	Wins = "wins"
+	// BackerProfit ranks the backers by returned points minus staked points as backers
+	BackerProfit = "backer_profit"
+)
+
+// Periods of the leaderboards, the periods are calendar day, week starting on Monday and month in UTC
+const (
+	AllTime = "all_time"
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+// auxiliary boards keep the totals which the metrics are calculated from
+const (
+	entries  = "entries"
+	backings = "backings"
+	staked   = "staked"
+	returned = "returned"
+)
+
+// Periods defines periods of the leaderboards which are updated by results of the tournaments
+var Periods = []string{AllTime, Daily, Weekly, Monthly}
+
+// Retention defines how long the leaderboards of the past periods are kept after the periods end,
+// zero retention keeps them forever
+var Retention = 90 * 24 * time.Hour
+
+// metrics lists the metrics and the auxiliary boards which are updated by results of the tournaments
+var metrics = []string{entries, backings, staked, returned, Winnings, Wins, BackerProfit, ROI}
+
+// Standing describes the place of the player on the leaderboard,
+// players with equal values share the same rank
+type Standing struct {
+	Player string
+	Rank   int
+	Value  float64
+}
+
+// Board returns the name of the datastore board of the metric within the period which includes the moment
+func Board(metric, period string, at time.Time) (string, error) {
+	switch metric {
+	case Winnings, ROI, Wins, BackerProfit, entries, backings, staked, returned:
+	default:
+		return "", ErrUnknownMetric
+	}
+	start, _, err := bounds(period, at)
+	if err != nil {
+		return "", err
+	}
+	switch period {
+	case Daily, Weekly:
+		return "leaderboard:" + metric + ":" + period + ":" + start.Format("2006-01-02"), nil
+	case Monthly:
+		return "leaderboard:" + metric + ":" + period + ":" + start.Format("2006-01"), nil
+	}
+	return "leaderboard:" + metric + ":" + period, nil
+}
+
+// bounds returns the start and the end of the period which includes the moment,
+// the all time period has no bounds
+func bounds(period string, at time.Time) (time.Time, time.Time, error) {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case AllTime:
+		return time.Time{}, time.Time{}, nil
+	case Daily:
+		return day, day.AddDate(0, 0, 1), nil
+	case Weekly:
+		week := day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+		return week, week.AddDate(0, 0, 7), nil
+	case Monthly:
+		month := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return month, month.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, ErrUnknownPeriod
+}
+
+// Top returns up to n leading players of the metric within the period which includes the moment
+func Top(ctrl datastore.Controller, metric, period string, at time.Time, n int) ([]Standing, error) {
+	board, err := Board(metric, period, at)
+	if err != nil {
+		return nil, err
+	}
+	scores, err := ctrl.ListScores(board, n, nil)
+	if err != nil {
+		return nil, err
+	}
+	standings := make([]Standing, len(scores))
+	for idx, score := range scores {
+		standings[idx] = Standing{Player: score.Member, Rank: score.Rank, Value: score.Value}
+	}
+	return standings, nil
+}
+
+// Rank returns the standing of the player on the leaderboard of the metric within the period
+// which includes the moment, datastore.ErrRecordNotFound appears if the player is not on the leaderboard
+func Rank(ctrl datastore.Controller, metric, period string, at time.Time, player string) (*Standing, error) {
+	board, err := Board(metric, period, at)
+	if err != nil {
+		return nil, err
+	}
+	score, err := ctrl.FindScore(board, player, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Standing{Player: score.Member, Rank: score.Rank, Value: score.Value}, nil
+}
+
+// Record adds the finished tournament to the leaderboards of the periods which include its finish,
+// only tournaments in the default points are ranked
+func Record(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament) error {
+	return apply(ctrl, tx, tournament, 1)
+}
+
+// Revert removes the tournament from the leaderboards when its result is reversed,
+// it should be called before the finish of the tournament is cleared
+func Revert(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament) error {
+	return apply(ctrl, tx, tournament, -1)
+}
+
+// apply adds the tournament to the leaderboards with the sign
+func apply(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament, sign float64) error {
+	if tournament.Currency != "" || tournament.Finished.IsZero() {
+		return nil
+	}
+	changes := stats.Shares(tournament)
+	wins := make(map[string]int)
+	for _, bidder := range tournament.Bidders {
+		if bidder.Winner {
+			wins[bidder.ID]++
+		}
+	}
+	// players are locked in the same order to avoid deadlocks,
+	// scores of removed players are kept under the lock as well
+	IDs := make([]string, 0, len(changes))
+	for ID := range changes {
+		IDs = append(IDs, ID)
+	}
+	sort.Strings(IDs)
+	for _, ID := range IDs {
+		if _, err := ctrl.FindPlayerForUpdate(ID, tx); err != nil && err != datastore.ErrRecordNotFound {
+			return err
+		}
+	}
+	for _, period := range Periods {
+		for _, ID := range IDs {
+			change := changes[ID]
+			deltas := map[string]float64{
+				entries:      float64(change.Bidder.Entries + change.Backer.Entries),
+				backings:     float64(change.Backer.Entries),
+				staked:       float64(change.Bidder.Staked + change.Backer.Staked),
+				returned:     float64(change.Bidder.Returned + change.Backer.Returned),
+				Winnings:     float64(change.Bidder.Returned + change.Backer.Returned),
+				Wins:         float64(wins[ID]),
+				BackerProfit: float64(change.Backer.Returned - change.Backer.Staked),
+			}
+			if err := update(ctrl, tx, ID, period, tournament.Finished, deltas, sign); err != nil {
+				return err
+			}
+		}
+		if err := expire(ctrl, tx, period, tournament.Finished); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expire sets the expiry of the leaderboards of the past period after the Retention
+func expire(ctrl datastore.Controller, tx datastore.Transact, period string, at time.Time) error {
+	_, end, err := bounds(period, at)
+	if err != nil || end.IsZero() || Retention <= 0 {
+		return err
+	}
+	for _, metric := range metrics {
+		board, err := Board(metric, period, at)
+		if err != nil {
+			return err
+		}
+		if err := ctrl.ExpireBoard(board, end.Add(Retention), tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// update changes scores of the player within the period by the deltas with the sign and recalculates ROI,
+// the player is removed from the leaderboards of the period if it has no entries
+// and from the backers' profit leaderboard if it has no backings
+func update(ctrl datastore.Controller, tx datastore.Transact,
+	ID, period string, at time.Time, deltas map[string]float64, sign float64) error {
+	values := make(map[string]float64, len(deltas))
+	boards := make(map[string]string, len(deltas)+1)
+	for _, metric := range metrics {
+		board, err := Board(metric, period, at)
+		if err != nil {
+			return err
+		}
+		boards[metric] = board
+		if metric == ROI {
+			continue
+		}
+		value, err := ctrl.FindScoreValue(board, ID, tx)
+		if err != nil && err != datastore.ErrRecordNotFound {
+			return err
+		}
+		values[metric] = helper.Round(value+sign*deltas[metric], 0.5, 2)
+	}
+	values[ROI] = 0
+	if values[staked] > 0 {
+		values[ROI] = helper.Round((values[returned]-values[staked])/values[staked], 0.5, 4)
+	}
+	for metric, board := range boards {
+		var err error
+		switch {
+		case values[entries] <= 0, metric == BackerProfit && values[backings] <= 0:
+			err = ctrl.DeleteScore(board, ID, tx)
+		default:
+			err = ctrl.SaveScore(board, ID, values[metric], tx)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package leaderboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/datastore/redistest"
+	"github.com/takama/backer/model"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func TestBoard(t *testing.T) {
+
+	at := time.Date(2018, 3, 15, 23, 30, 0, 0, time.UTC)
+	for period, expected := range map[string]string{
+		AllTime: "leaderboard:wins:all_time",
+		Daily:   "leaderboard:wins:daily:2018-03-15",
+		Weekly:  "leaderboard:wins:weekly:2018-03-12",
+		Monthly: "leaderboard:wins:monthly:2018-03",
+	} {
+		board, err := Board(Wins, period, at)
+		test(t, err == nil && board == expected, "Expected board", expected, "got", board, err)
+	}
+	_, err := Board("losses", AllTime, at)
+	test(t, err == ErrUnknownMetric, "Expected", ErrUnknownMetric, "got", err)
+	_, err = Board(Wins, "yearly", at)
+	test(t, err == ErrUnknownPeriod, "Expected", ErrUnknownPeriod, "got", err)
+}
+
+func TestRecord(t *testing.T) {
+
+	server := redistest.NewServer()
+	redis := datastore.NewRedis("test:", func() (datastore.RedisConn, error) {
+		return server.Conn(), nil
+	})
+	testRecord(t, new(datastore.Stub))
+	testRecord(t, redis)
+}
+
+// store combines store and controller methods to test the leaderboards with every backend
+type store interface {
+	datastore.Store
+	datastore.Controller
+}
+
+func testRecord(t *testing.T, store store) {
+
+	store.Reset()
+	for _, id := range []string{"p1", "p2", "b1"} {
+		store.NewPlayer(id, nil)
+	}
+	// leaderboards of the past periods expire after the Retention, so the tournaments finish this week
+	now := time.Now().UTC()
+	monday := time.Date(now.Year(), now.Month(), now.Day()-(int(now.Weekday())+6)%7, 10, 0, 0, 0, time.UTC)
+	tournaments := []model.Tournament{
+		{ID: 1, Deposit: 300, IsFinished: true, Finished: monday, Bidders: []model.Bidder{
+			{ID: "p1", Winner: true, Prize: 900, Backers: []string{"b1", "b2"}},
+			{ID: "p2"},
+		}},
+		{ID: 2, Deposit: 100, IsFinished: true, Finished: monday.AddDate(0, 0, 1), Bidders: []model.Bidder{
+			{ID: "p2", Winner: true, Prize: 150, Backers: []string{"b1"}},
+			{ID: "p1"},
+		}},
+		{ID: 3, Deposit: 100, Currency: "tickets", IsFinished: true, Finished: monday, Bidders: []model.Bidder{
+			{ID: "p1", Winner: true, Prize: 500},
+		}},
+	}
+	for idx := range tournaments {
+		err := datastore.WithTransaction(store, func(tx datastore.Transact) error {
+			return Record(store, tx, &tournaments[idx])
+		})
+		test(t, err == nil, "Expected record of the tournament, got", err)
+	}
+
+	top, err := Top(store, Winnings, Weekly, monday, 10)
+	test(t, err == nil, "Expected winnings leaderboard, got", err)
+	test(t, len(top) == 4, "Expected 4 players on the leaderboard, got", top)
+	if len(top) == 4 {
+		test(t, top[0].Player == "b1" && top[0].Value == 375 && top[0].Rank == 1,
+			"Expected b1 with 375 on the top, got", top[0])
+		test(t, top[1].Value == 300 && top[2].Value == 300 && top[1].Rank == 2 && top[2].Rank == 2,
+			"Expected p1 and b2 with 300 share the rank, got", top[1], top[2])
+		test(t, top[3].Player == "p2" && top[3].Value == 75 && top[3].Rank == 4,
+			"Expected p2 with 75 the last, got", top[3])
+	}
+	top, err = Top(store, Winnings, Daily, monday, 1)
+	test(t, err == nil && len(top) == 1 && top[0].Value == 300,
+		"Expected the top of the day with 300, got", top, err)
+
+	standing, err := Rank(store, ROI, AllTime, monday, "b1")
+	test(t, err == nil && standing.Value == 1.5 && standing.Rank == 2,
+		"Expected b1 ROI 1.5 ranked 2 after b2, got", standing, err)
+	standing, err = Rank(store, ROI, Monthly, monday, "p2")
+	test(t, err == nil && standing.Value == -0.7857, "Expected p2 ROI -0.7857, got", standing, err)
+	standing, err = Rank(store, BackerProfit, AllTime, monday, "b1")
+	test(t, err == nil && standing.Value == 225, "Expected b1 backers' profit 225, got", standing, err)
+	_, err = Rank(store, BackerProfit, AllTime, monday, "p1")
+	test(t, err == datastore.ErrRecordNotFound, "Expected p1 is not a backer, got", err)
+	top, err = Top(store, Wins, AllTime, monday, 2)
+	test(t, err == nil && len(top) == 2 && top[0].Value == 1 && top[1].Value == 1,
+		"Expected two winners, got", top, err)
+
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		return Revert(store, tx, &tournaments[1])
+	})
+	test(t, err == nil, "Expected revert of the tournament, got", err)
+	_, err = Rank(store, Winnings, Daily, monday.AddDate(0, 0, 1), "p2")
+	test(t, err == datastore.ErrRecordNotFound, "Expected removed p2 from the daily leaderboard, got", err)
+	standing, err = Rank(store, Wins, Weekly, monday, "p2")
+	test(t, err == nil && standing.Value == 0 && standing.Rank == 2,
+		"Expected p2 without wins ranked 2, got", standing, err)
+	standing, err = Rank(store, ROI, AllTime, monday, "b1")
+	test(t, err == nil && standing.Value == 2, "Expected b1 ROI 2, got", standing, err)
+
+	past := monday.Add(-Retention).AddDate(0, -1, 0)
+	tournament := model.Tournament{ID: 4, Deposit: 100, IsFinished: true, Finished: past, Bidders: []model.Bidder{
+		{ID: "p1", Winner: true, Prize: 100},
+	}}
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		return Record(store, tx, &tournament)
+	})
+	test(t, err == nil, "Expected record of the tournament, got", err)
+	for _, period := range []string{Daily, Weekly, Monthly} {
+		top, err = Top(store, Wins, period, past, 10)
+		test(t, err == nil && len(top) == 0, "Expected expired leaderboard of the past period, got", top, err)
+	}
+	standing, err = Rank(store, Wins, AllTime, past, "p1")
+	test(t, err == nil && standing.Value == 2, "Expected p1 with 2 wins of all time, got", standing, err)
+}
@@ -0,0 +1,46 @@
+package ledger
+
+import (
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Credit describes the credit line of the player, outstanding credit is the negative part of the balance
+type Credit struct {
+	Player      string
+	Limit       backer.Points
+	Outstanding backer.Points
+}
+
+// Outstanding returns outstanding credit of the player, it is repaid by the following credits like prizes
+func Outstanding(player *model.Player) backer.Points {
+	if player.Balance >= 0 {
+		return 0
+	}
+	return -player.Balance
+}
+
+// Credits returns credit lines of the players including archived ones which have the credit limit
+// or outstanding credit and the total outstanding credit
+func Credits(ctrl datastore.Controller, tx datastore.Transact) ([]Credit, backer.Points, error) {
+	credits := make([]Credit, 0)
+	var total backer.Points
+	for _, archived := range []bool{false, true} {
+		err := eachPlayer(ctrl, tx, archived, func(player model.Player) {
+			outstanding := Outstanding(&player)
+			if player.CreditLimit > 0 || outstanding > 0 {
+				credits = append(credits, Credit{
+					Player:      player.ID,
+					Limit:       player.CreditLimit,
+					Outstanding: outstanding,
+				})
+				total = round(total + outstanding)
+			}
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return credits, total, nil
+}
@@ -0,0 +1,149 @@
+package ledger
+
+import (
+	"sort"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// FundExpiry defines how long funded points are kept by the player, zero means that funded points never expire
+var FundExpiry time.Duration
+
+// Expiring returns lots of the player which expire after the moment and not later than until
+func Expiring(ctrl datastore.Controller, tx datastore.Transact, ID string, now, until time.Time) ([]model.Lot, error) {
+	stored, err := ctrl.FindLots(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	lots := make([]model.Lot, 0)
+	for _, lot := range stored {
+		if lot.Expires.After(now) && !lot.Expires.After(until) {
+			lots = append(lots, lot)
+		}
+	}
+	return lots, nil
+}
+
+// Expire moves points of the expired lots of the player to the house,
+// the operation is nil if the player has no expired lots
+func Expire(ctrl datastore.Controller, tx datastore.Transact, ID string, now time.Time) (*model.Operation, error) {
+	player, err := ctrl.FindPlayerForUpdate(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := ctrl.FindLots(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	var expired backer.Points
+	lots := make([]model.Lot, 0, len(stored))
+	for _, lot := range stored {
+		if lot.Expires.After(now) {
+			lots = append(lots, lot)
+		} else {
+			expired = round(expired + lot.Amount)
+		}
+	}
+	if len(lots) == len(stored) {
+		return nil, nil
+	}
+	if err := ctrl.SaveLots(ID, lots, tx); err != nil {
+		return nil, err
+	}
+	if expired > player.Balance {
+		expired = player.Balance
+	}
+	if expired <= 0 {
+		return nil, nil
+	}
+	operation := New(Expiry, Player(ID), House, expired)
+	// held points expire as well, so the available points could become negative
+	if err := record(ctrl, tx, operation, true); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+// Sweep expires lots of all active players, every player is processed in its own unit of work
+func Sweep(ctrl datastore.Controller, now time.Time) ([]model.Operation, error) {
+	players := make([]string, 0)
+	err := eachPlayer(ctrl, nil, false, func(player model.Player) {
+		players = append(players, player.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	IDs := make([]string, 0)
+	for _, ID := range players {
+		lots, err := ctrl.FindLots(ID, nil)
+		if err != nil {
+			return nil, err
+		}
+		for _, lot := range lots {
+			if !lot.Expires.After(now) {
+				IDs = append(IDs, ID)
+				break
+			}
+		}
+	}
+	operations := make([]model.Operation, 0)
+	for _, ID := range IDs {
+		err := datastore.WithTransaction(ctrl, func(tx datastore.Transact) error {
+			operation, err := Expire(ctrl, tx, ID, now)
+			if err == nil && operation != nil {
+				operations = append(operations, *operation)
+			}
+			return err
+		})
+		if err != nil {
+			return operations, err
+		}
+	}
+	return operations, nil
+}
+
+// spendLots keeps lots of the player in line with the posting amount which is already applied to the balance,
+// funded points are added as a new lot except the points which repay the credit
+// and spent points are taken from the oldest lots first, lots are read only by postings which could change them
+func spendLots(ctrl datastore.Controller, tx datastore.Transact,
+	player *model.Player, operation *model.Operation, amount backer.Points) error {
+	if amount > 0 && amount > player.Balance {
+		amount = player.Balance
+	}
+	funded := amount > 0 && operation.Kind == Fund && FundExpiry > 0
+	if operation.Currency != "" || !funded && (amount >= 0 || operation.Kind == Expiry) {
+		return nil
+	}
+	lots, err := ctrl.FindLots(player.ID, tx)
+	if err != nil {
+		return err
+	}
+	if funded {
+		lots = append(lots, model.Lot{
+			Operation: operation.ID,
+			Amount:    amount,
+			Created:   operation.Created,
+			Expires:   operation.Created.Add(FundExpiry),
+		})
+		return ctrl.SaveLots(player.ID, lots, tx)
+	}
+	if len(lots) == 0 {
+		return nil
+	}
+	sort.SliceStable(lots, func(i, j int) bool {
+		return lots[i].Created.Before(lots[j].Created)
+	})
+	spent := -amount
+	for len(lots) > 0 && spent > 0 {
+		if lots[0].Amount > spent {
+			lots[0].Amount = round(lots[0].Amount - spent)
+			break
+		}
+		spent = round(spent - lots[0].Amount)
+		lots = lots[1:]
+	}
+	return ctrl.SaveLots(player.ID, lots, tx)
+}
@@ -0,0 +1,114 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrHoldNotFound appears if the hold does not exist, it was already captured, released or expired
+	ErrHoldNotFound = errors.New("Hold not found")
+	// ErrInvalidHold appears if the hold amount is not positive or the hold expires in the past
+	ErrInvalidHold = errors.New("Hold should have positive amount and expire in the future")
+)
+
+// Held returns points of the player which are earmarked by holds which are not expired at the moment
+func Held(player *model.Player, now time.Time) backer.Points {
+	var held backer.Points
+	for _, hold := range player.Holds {
+		if now.Before(hold.Expires) {
+			held = round(held + hold.Amount)
+		}
+	}
+	return held
+}
+
+// Available returns points of the player which could be spent at the moment including the credit line
+func Available(player *model.Player, now time.Time) backer.Points {
+	return round(player.Balance + player.CreditLimit - Held(player, now))
+}
+
+// PlaceHold earmarks amount of funded points of the player until the hold expires,
+// held points could not be spent until the hold is captured, released or expired
+func PlaceHold(ctrl datastore.Controller, tx datastore.Transact,
+	ID string, amount backer.Points, expires time.Time) (*model.Hold, error) {
+	now := time.Now()
+	amount = truncate(amount)
+	if amount <= 0 || !now.Before(expires) {
+		return nil, ErrInvalidHold
+	}
+	player, err := ctrl.FindPlayerForUpdate(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckStatus(player); err != nil {
+		return nil, err
+	}
+	if Funded(player, "", now) < amount {
+		return nil, ErrInsufficientPoints
+	}
+	holdID, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	hold := model.Hold{ID: holdID, Amount: amount, Created: now, Expires: expires}
+	player.Holds = append(unexpired(player.Holds, now), hold)
+	if err := ctrl.SavePlayer(player, tx); err != nil {
+		return nil, err
+	}
+	return &hold, nil
+}
+
+// CaptureHold takes held points from the player to the house and removes the hold
+func CaptureHold(ctrl datastore.Controller, tx datastore.Transact, ID, hold string) (*model.Operation, error) {
+	captured, err := removeHold(ctrl, tx, ID, hold)
+	if err != nil {
+		return nil, err
+	}
+	operation := New(Take, Player(ID), House, captured.Amount)
+	operation.Memo = "hold:" + captured.ID
+	if err := Post(ctrl, tx, operation); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+// ReleaseHold removes the hold and makes held points available again
+func ReleaseHold(ctrl datastore.Controller, tx datastore.Transact, ID, hold string) error {
+	_, err := removeHold(ctrl, tx, ID, hold)
+	return err
+}
+
+func removeHold(ctrl datastore.Controller, tx datastore.Transact, ID, hold string) (*model.Hold, error) {
+	now := time.Now()
+	player, err := ctrl.FindPlayerForUpdate(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	holds := unexpired(player.Holds, now)
+	for idx, removed := range holds {
+		if removed.ID == hold {
+			player.Holds = append(holds[:idx], holds[idx+1:]...)
+			if err := ctrl.SavePlayer(player, tx); err != nil {
+				return nil, err
+			}
+			return &removed, nil
+		}
+	}
+	return nil, ErrHoldNotFound
+}
+
+// unexpired returns holds which are not expired at the moment
+func unexpired(holds []model.Hold, now time.Time) []model.Hold {
+	result := make([]model.Hold, 0, len(holds))
+	for _, hold := range holds {
+		if now.Before(hold.Expires) {
+			result = append(result, hold)
+		}
+	}
+	return result
+}
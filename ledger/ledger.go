@@ -0,0 +1,225 @@
+// Package ledger records points movements as balanced double-entry operations
+// between player accounts, tournament escrow accounts and the house account
+package ledger
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/helper"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrInsufficientPoints appears if player has not enough points
+	ErrInsufficientPoints = errors.New("Insufficient points")
+	// ErrUnbalanced appears if amounts of the operation postings do not sum up to zero
+	ErrUnbalanced = errors.New("Operation postings are not balanced")
+	// ErrAccountSuspended appears if points are taken from the suspended account
+	// or credited to the suspended account with frozen credits
+	ErrAccountSuspended = errors.New("Player account is suspended")
+	// ErrAccountClosed appears if the closed account is changed
+	ErrAccountClosed = errors.New("Player account is closed")
+)
+
+// Kinds of the operations
+const (
+	// Fund moves points from the house to the player
+	Fund = "fund"
+	// Take moves points from the player to the house
+	Take = "take"
+	// Contribution moves deposit shares from the bidder and backers to the tournament escrow
+	Contribution = "contribution"
+	// Prize moves prize shares from the tournament escrow to the winner and backers
+	Prize = "prize"
+	// Fee moves the rest of the tournament escrow to the house,
+	// negative fee means that the house covers prizes which exceed deposits
+	Fee = "fee"
+	// Reversal compensates movements of another operation
+	Reversal = "reversal"
+	// Transfer moves points from one player to another
+	Transfer = "transfer"
+	// Expiry moves expired funded points from the player to the house
+	Expiry = "expiry"
+)
+
+// House is the account of the points which are not owned by players or tournaments
+const House = "house"
+
+const (
+	playerPrefix = "player:"
+	escrowPrefix = "escrow:"
+)
+
+// Player returns the account of the player
+func Player(ID string) string {
+	return playerPrefix + ID
+}
+
+// Escrow returns the escrow account of the tournament
+func Escrow(ID uint64) string {
+	return escrowPrefix + strconv.FormatUint(ID, 10)
+}
+
+// PlayerOf returns ID of the player which owns the account
+func PlayerOf(account string) (string, bool) {
+	if !strings.HasPrefix(account, playerPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(account, playerPrefix), true
+}
+
+// New returns operation of the kind which moves amount from one account to another
+func New(kind, from, to string, amount backer.Points) *model.Operation {
+	amount = truncate(amount)
+	return &model.Operation{
+		Kind: kind,
+		Postings: []model.Posting{
+			{Account: from, Amount: -amount},
+			{Account: to, Amount: amount},
+		},
+	}
+}
+
+// Collect returns operation of the kind which moves equal shares from the accounts to one account
+func Collect(kind, to string, share backer.Points, from ...string) *model.Operation {
+	share = truncate(share)
+	operation := &model.Operation{Kind: kind}
+	for _, account := range from {
+		operation.Postings = append(operation.Postings, model.Posting{Account: account, Amount: -share})
+	}
+	operation.Postings = append(operation.Postings,
+		model.Posting{Account: to, Amount: round(share * backer.Points(len(from)))})
+	return operation
+}
+
+// Distribute returns operation of the kind which moves equal shares from one account to the accounts
+func Distribute(kind, from string, share backer.Points, to ...string) *model.Operation {
+	share = truncate(share)
+	operation := &model.Operation{Kind: kind}
+	operation.Postings = append(operation.Postings,
+		model.Posting{Account: from, Amount: -round(share * backer.Points(len(to)))})
+	for _, account := range to {
+		operation.Postings = append(operation.Postings, model.Posting{Account: account, Amount: share})
+	}
+	return operation
+}
+
+// Post records the operation and applies its postings to balances of the players within the transaction,
+// resulting balances of the players are kept in their postings,
+// it should be called within a unit of work (see datastore.WithTransaction) to discard partial changes,
+// the operation is rejected if its postings are not balanced or a player has insufficient available points,
+// missing ID and creation date of the operation are generated
+func Post(ctrl datastore.Controller, tx datastore.Transact, operation *model.Operation) error {
+	return record(ctrl, tx, operation, false)
+}
+
+// record posts the operation, balances of the players could become negative if overdraft is set
+func record(ctrl datastore.Controller, tx datastore.Transact, operation *model.Operation, overdraft bool) error {
+	var sum backer.Points
+	for idx := range operation.Postings {
+		operation.Postings[idx].Amount = truncate(operation.Postings[idx].Amount)
+		sum += operation.Postings[idx].Amount
+	}
+	if round(sum) != 0 {
+		return ErrUnbalanced
+	}
+	if operation.ID == "" {
+		ID, err := newID()
+		if err != nil {
+			return err
+		}
+		operation.ID = ID
+	}
+	if operation.Created.IsZero() {
+		operation.Created = time.Now()
+	}
+	for idx, posting := range operation.Postings {
+		ID, ok := PlayerOf(posting.Account)
+		if !ok {
+			continue
+		}
+		player, err := ctrl.FindPlayerForUpdate(ID, tx)
+		if err != nil {
+			return err
+		}
+		if err := permit(player, operation, posting.Amount); err != nil {
+			return err
+		}
+		available := Spendable(player, operation.Currency, time.Now())
+		if operation.Kind == Take || operation.Kind == Transfer {
+			available = Funded(player, operation.Currency, time.Now())
+		}
+		if !overdraft && posting.Amount < 0 && available < -posting.Amount {
+			return ErrInsufficientPoints
+		}
+		balance := credit(player, operation, posting.Amount)
+		if err := ctrl.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		if err := spendLots(ctrl, tx, player, operation, posting.Amount); err != nil {
+			return err
+		}
+		operation.Postings[idx].Balance = balance
+	}
+	return ctrl.NewOperation(operation, tx)
+}
+
+// Balance returns the balance of the account in the default points summed up from its postings
+func Balance(ctrl datastore.Controller, tx datastore.Transact, account string) (backer.Points, error) {
+	return BalanceIn(ctrl, tx, account, "")
+}
+
+// permit checks whether the status of the player account allows the posting,
+// reversals and expiries are allowed to settle accounts under investigation
+func permit(player *model.Player, operation *model.Operation, amount backer.Points) error {
+	if operation.Kind == Reversal || operation.Kind == Expiry {
+		return nil
+	}
+	if amount > 0 {
+		return CheckCredit(player)
+	}
+	return CheckStatus(player)
+}
+
+// CheckCredit returns an error if the player account could not be credited,
+// the suspended account is credited unless its credits are frozen
+func CheckCredit(player *model.Player) error {
+	if player.Status == model.Suspended && !player.FrozenCredits {
+		return nil
+	}
+	return CheckStatus(player)
+}
+
+// CheckStatus returns an error if the player account is suspended or closed
+func CheckStatus(player *model.Player) error {
+	switch player.Status {
+	case model.Closed:
+		return ErrAccountClosed
+	case model.Suspended:
+		return ErrAccountSuspended
+	}
+	return nil
+}
+
+func newID() (string, error) {
+	data := make([]byte, 16)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(data), nil
+}
+
+func truncate(amount backer.Points) backer.Points {
+	return backer.Points(helper.TruncatePrice(float32(amount)))
+}
+
+func round(amount backer.Points) backer.Points {
+	return backer.Points(helper.RoundPrice(float32(amount)))
+}
@@ -0,0 +1,416 @@
+package ledger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func post(store datastore.Controller, operation *model.Operation) error {
+	return datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		return Post(store, tx, operation)
+	})
+}
+
+func TestPost(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewPlayer("p2", nil)
+
+	err := post(store, New(Fund, House, Player("p1"), 100.555))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 100.55, "Expected 100.55 points for the player, got", player.Balance)
+
+	err = post(store, &model.Operation{Kind: Fund, Postings: []model.Posting{
+		{Account: House, Amount: -10},
+		{Account: Player("p1"), Amount: 20},
+	}})
+	test(t, err == ErrUnbalanced, "Expected", ErrUnbalanced, "got", err)
+
+	operation := Collect(Contribution, Escrow(1), 50, Player("p1"), Player("p2"))
+	err = post(store, operation)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	player, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 100.55, "Expected 100.55 points for the player, got", player.Balance)
+
+	err = post(store, New(Fund, House, Player("p2"), 50))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	operation = Collect(Contribution, Escrow(1), 50, Player("p1"), Player("p2"))
+	err = post(store, operation)
+	test(t, err == nil, "Expected post of the operation, got", err)
+	test(t, operation.ID != "" && !operation.Created.IsZero(),
+		"Expected generated ID and creation date of the operation, got", operation)
+	stored, err := store.FindOperation(operation.ID, nil)
+	test(t, err == nil, "Expected find the operation, got", err)
+	test(t, len(stored.Postings) == 3 && stored.Postings[2].Amount == 100,
+		"Expected 100 points to the escrow, got", stored.Postings)
+
+	err = post(store, Distribute(Prize, Escrow(1), 75, Player("p1"), Player("p2")))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	balance, err := Balance(store, nil, Escrow(1))
+	test(t, err == nil, "Expected balance of the escrow, got", err)
+	test(t, balance == -50, "Expected -50 points of the escrow, got", balance)
+	balance, err = Balance(store, nil, Player("p2"))
+	test(t, err == nil, "Expected balance of the player, got", err)
+	test(t, balance == 75, "Expected 75 points of the player, got", balance)
+	balance, err = Balance(store, nil, House)
+	test(t, err == nil, "Expected balance of the house, got", err)
+	test(t, balance == -150.55, "Expected -150.55 points of the house, got", balance)
+
+	store.NewPlayer("p3", nil)
+	err = post(store, New(Take, Player("p3"), House, 10))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = post(store, New(Take, Player("p4"), House, 10))
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+}
+
+func TestReconcile(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewTournament(1, nil)
+	post(store, New(Fund, House, Player("p1"), 100))
+	post(store, New(Contribution, Player("p1"), Escrow(1), 40))
+
+	report, err := Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+	test(t, report.Accounts[Player("p1")] == 60, "Expected 60 points of the player, got", report.Accounts)
+	test(t, report.Accounts[House] == -100, "Expected -100 points of the house, got", report.Accounts)
+
+	player, _ := store.FindPlayer("p1", nil)
+	player.Balance = 70
+	store.SavePlayer(player, nil)
+	store.ArchivePlayer("p1", nil)
+	tournament, _ := store.FindTournament(1, nil)
+	tournament.IsFinished = true
+	store.SaveTournament(tournament, nil)
+	store.NewOperation(&model.Operation{ID: "o1", Postings: []model.Posting{{Account: House, Amount: 5}}}, nil)
+	store.NewOperation(&model.Operation{ID: "o2", Currency: "tickets", Postings: []model.Posting{
+		{Account: House, Amount: -30}, {Account: Player("p2"), Amount: 30},
+	}}, nil)
+
+	report, err = Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, !report.Reconciled(), "Expected mismatches of the ledger")
+	test(t, len(report.Mismatches) == 3, "Expected 3 mismatches, got", report.Mismatches)
+	if len(report.Mismatches) == 3 {
+		test(t, report.Mismatches[0] == Mismatch{Account: Player("p1"), Balance: 70, Ledger: 60},
+			"Expected mismatch of the player, got", report.Mismatches[0])
+		test(t, report.Mismatches[1] == Mismatch{Account: Wallet(Player("p2"), "tickets"), Ledger: 30},
+			"Expected mismatch of the wallet without player, got", report.Mismatches[1])
+		test(t, report.Mismatches[2] == Mismatch{Account: Escrow(1), Ledger: 40},
+			"Expected mismatch of the escrow, got", report.Mismatches[2])
+	}
+	test(t, len(report.Unbalanced) == 1 && report.Unbalanced[0] == "o1",
+		"Expected unbalanced operation, got", report.Unbalanced)
+}
+
+func TestStatement(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewPlayer("b1", nil)
+	start := time.Now()
+	operations := []*model.Operation{
+		New(Fund, House, Player("p1"), 300),
+		New(Fund, House, Player("b1"), 300),
+		Collect(Contribution, Escrow(1), 100, Player("p1"), Player("b1")),
+		Distribute(Prize, Escrow(1), 150, Player("p1"), Player("b1")),
+		New(Take, Player("p1"), House, 50),
+	}
+	for idx, operation := range operations {
+		operation.Created = start.Add(time.Duration(idx) * time.Hour)
+		operation.Memo = "memo"
+		err := post(store, operation)
+		test(t, err == nil, "Expected post of the operation, got", err)
+	}
+
+	movements, next, err := Statement(store, nil, "p1", time.Time{}, time.Time{}, datastore.Page{Limit: 3})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, next != "", "Expected the next page of the statement")
+	test(t, len(movements) == 3, "Expected 3 movements, got", len(movements))
+	test(t, movements[0].Kind == Fund && movements[0].Amount == 300 && movements[0].Balance == 300 &&
+		len(movements[0].Counterparties) == 1 && movements[0].Counterparties[0] == House,
+		"Expected fund from the house, got", movements[0])
+	test(t, movements[1].Kind == Contribution && movements[1].Amount == -100 && movements[1].Balance == 200 &&
+		len(movements[1].Counterparties) == 1 && movements[1].Counterparties[0] == Escrow(1),
+		"Expected contribution to the escrow, got", movements[1])
+	test(t, movements[2].Kind == Prize && movements[2].Amount == 150 && movements[2].Balance == 350 &&
+		movements[2].Memo == "memo", "Expected prize from the escrow, got", movements[2])
+
+	movements, next, err = Statement(store, nil, "p1", time.Time{}, time.Time{},
+		datastore.Page{Cursor: next, Limit: 3})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, next == "", "Expected no more pages, got", next)
+	test(t, len(movements) == 1 && movements[0].Amount == -50 && movements[0].Balance == 300,
+		"Expected take to the house, got", movements)
+
+	movements, _, err = Statement(store, nil, "b1", start.Add(2*time.Hour), start.Add(4*time.Hour),
+		datastore.Page{Order: datastore.Descending})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, len(movements) == 2 && movements[0].Kind == Prize && movements[1].Kind == Contribution,
+		"Expected prize and contribution, got", movements)
+}
+
+func TestReverse(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	reverse := func(ID string, policy Policy) (reversal *model.Operation, err error) {
+		err = datastore.WithTransaction(store, func(tx datastore.Transact) (err error) {
+			reversal, err = Reverse(store, tx, ID, "admin", "mistake", policy)
+			return err
+		})
+		return reversal, err
+	}
+	balance := func() backer.Points {
+		player, err := store.FindPlayer("p1", nil)
+		test(t, err == nil, "Expected find the player, got", err)
+		return player.Balance
+	}
+
+	funds := make([]*model.Operation, 4)
+	for idx := range funds {
+		funds[idx] = New(Fund, House, Player("p1"), 100)
+		err := post(store, funds[idx])
+		test(t, err == nil, "Expected post of the operation, got", err)
+	}
+	reversal, err := reverse(funds[0].ID, FailInsufficient)
+	test(t, err == nil, "Expected reversal of the operation, got", err)
+	test(t, reversal.ID == ReversalOf(funds[0].ID) && reversal.Kind == Reversal &&
+		reversal.Reverses == funds[0].ID && reversal.Actor == "admin" && reversal.Memo == "mistake",
+		"Expected reversal details, got", reversal)
+	test(t, balance() == 300, "Expected 300 points for the player, got", balance())
+	_, err = reverse(funds[0].ID, FailInsufficient)
+	test(t, err == ErrAlreadyReversed, "Expected", ErrAlreadyReversed, "got", err)
+	_, err = reverse("unknown", FailInsufficient)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+
+	err = post(store, New(Take, Player("p1"), House, 250))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	_, err = reverse(funds[1].ID, FailInsufficient)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	test(t, balance() == 50, "Expected 50 points for the player, got", balance())
+
+	reversal, err = reverse(funds[1].ID, PartialClawback)
+	test(t, err == nil, "Expected reversal of the operation, got", err)
+	test(t, balance() == 0, "Expected 0 points for the player, got", balance())
+	test(t, len(reversal.Postings) == 3 && reversal.Postings[2].Account == House &&
+		reversal.Postings[2].Amount == -50, "Expected shortfall covered by the house, got", reversal.Postings)
+
+	reversal, err = reverse(funds[2].ID, AllowNegative)
+	test(t, err == nil, "Expected reversal of the operation, got", err)
+	test(t, balance() == -100, "Expected -100 points for the player, got", balance())
+	_, err = reverse(funds[3].ID, PartialClawback)
+	test(t, err == nil, "Expected reversal of the operation, got", err)
+	test(t, balance() == -100, "Expected -100 points for the player, got", balance())
+
+	report, err := Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+	test(t, report.Accounts[House] == 100, "Expected 100 points of the house, got", report.Accounts[House])
+
+	store.NewPlayer("p2", nil)
+	err = post(store, New(Fund, House, Player("p2"), 100))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	err = store.NewTournament(1, nil)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	contribution := Collect(Contribution, Escrow(1), 60, Player("p2"))
+	contribution.Tournament = 1
+	err = post(store, contribution)
+	test(t, err == nil, "Expected post of the operation, got", err)
+	_, err = reverse(contribution.ID, FailInsufficient)
+	test(t, err == ErrOpenTournament, "Expected", ErrOpenTournament, "got", err)
+}
+
+func TestHolds(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	err := post(store, New(Fund, House, Player("p1"), 300))
+	test(t, err == nil, "Expected post of the operation, got", err)
+
+	now := time.Now()
+	_, err = PlaceHold(store, nil, "p1", 0, now.Add(time.Hour))
+	test(t, err == ErrInvalidHold, "Expected", ErrInvalidHold, "got", err)
+	_, err = PlaceHold(store, nil, "p1", 100, now)
+	test(t, err == ErrInvalidHold, "Expected", ErrInvalidHold, "got", err)
+	_, err = PlaceHold(store, nil, "p1", 400, now.Add(time.Hour))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	first, err := PlaceHold(store, nil, "p1", 200, now.Add(time.Hour))
+	test(t, err == nil, "Expected hold of the points, got", err)
+	second, err := PlaceHold(store, nil, "p1", 100, now.Add(50*time.Millisecond))
+	test(t, err == nil, "Expected hold of the points, got", err)
+	_, err = PlaceHold(store, nil, "p1", 1, now.Add(time.Hour))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+
+	player, _ := store.FindPlayer("p1", nil)
+	test(t, Held(player, time.Now()) == 300, "Expected 300 held points, got", Held(player, time.Now()))
+	test(t, Available(player, time.Now()) == 0, "Expected 0 available points, got", Available(player, time.Now()))
+	err = post(store, New(Take, Player("p1"), House, 10))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+
+	time.Sleep(100 * time.Millisecond)
+	err = ReleaseHold(store, nil, "p1", second.ID)
+	test(t, err == ErrHoldNotFound, "Expected", ErrHoldNotFound, "got", err)
+	err = post(store, New(Take, Player("p1"), House, 100))
+	test(t, err == nil, "Expected post of the operation, got", err)
+
+	var operation *model.Operation
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) (err error) {
+		operation, err = CaptureHold(store, tx, "p1", first.ID)
+		return err
+	})
+	test(t, err == nil, "Expected capture of the hold, got", err)
+	test(t, operation.Kind == Take && operation.Memo == "hold:"+first.ID && operation.Postings[0].Amount == -200,
+		"Expected take of the held points, got", operation)
+	err = ReleaseHold(store, nil, "p1", first.ID)
+	test(t, err == ErrHoldNotFound, "Expected", ErrHoldNotFound, "got", err)
+	player, _ = store.FindPlayer("p1", nil)
+	test(t, player.Balance == 0 && len(player.Holds) == 0, "Expected no points and holds, got", player)
+}
+
+func TestExpiry(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewPlayer("p2", nil)
+	defer func() { FundExpiry = 0 }()
+
+	err := post(store, New(Fund, House, Player("p1"), 50))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	FundExpiry = time.Hour
+	err = post(store, New(Fund, House, Player("p1"), 100))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	err = post(store, New(Fund, House, Player("p1"), 200))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	err = post(store, New(Fund, House, Player("p2"), 10))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	lots, err := store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 2, "Expected 2 lots of the player, got", lots, err)
+	lots, err = Expiring(store, nil, "p1", time.Now(), time.Now().Add(2*time.Hour))
+	test(t, err == nil && len(lots) == 2 && lots[0].Amount == 100 && lots[1].Amount == 200,
+		"Expected 100 and 200 points to expire, got", lots, err)
+	lots, err = Expiring(store, nil, "p1", time.Now(), time.Now().Add(time.Minute))
+	test(t, err == nil && len(lots) == 0, "Expected no points to expire within a minute, got", lots, err)
+
+	err = post(store, New(Take, Player("p1"), House, 150))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	lots, err = store.FindLots("p1", nil)
+	test(t, err == nil && len(lots) == 1 && lots[0].Amount == 150,
+		"Expected 150 points left in the newest lot, got", lots, err)
+
+	operations, err := Sweep(store, time.Now())
+	test(t, err == nil, "Expected sweep of the lots, got", err)
+	test(t, len(operations) == 0, "Expected no expired lots, got", operations)
+	operations, err = Sweep(store, time.Now().Add(2*time.Hour))
+	test(t, err == nil, "Expected sweep of the lots, got", err)
+	test(t, len(operations) == 2, "Expected 2 expiry operations, got", len(operations))
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	lots, err = store.FindLots("p1", nil)
+	test(t, err == nil && player.Balance == 50 && len(lots) == 0,
+		"Expected 50 points of the player without lots, got", player.Balance, lots, err)
+	balance, err := Balance(store, nil, House)
+	test(t, err == nil, "Expected balance of the house, got", err)
+	test(t, balance == -50, "Expected -50 points of the house, got", balance)
+	operations, err = Sweep(store, time.Now().Add(2*time.Hour))
+	test(t, err == nil, "Expected sweep of the lots, got", err)
+	test(t, len(operations) == 0, "Expected no expired lots, got", operations)
+
+	report, err := Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation of the ledger, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+}
+
+func TestWallets(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+
+	operation := New(Fund, House, Player("p1"), 100)
+	operation.Currency = "tickets"
+	err := post(store, operation)
+	test(t, err == nil, "Expected post of the operation, got", err)
+	test(t, operation.Postings[1].Balance == 100, "Expected 100 tickets in the posting, got", operation.Postings)
+	balance, err := BalanceIn(store, nil, Player("p1"), "tickets")
+	test(t, err == nil, "Expected balance of the account, got", err)
+	test(t, balance == 100, "Expected 100 tickets of the player, got", balance)
+	balance, err = Balance(store, nil, Player("p1"))
+	test(t, err == nil, "Expected balance of the account, got", err)
+	test(t, balance == 0, "Expected no points of the player, got", balance)
+
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	test(t, player.Balance == 0 && player.Wallets["tickets"] == 100,
+		"Expected 100 tickets of the player, got", player.Balance, player.Wallets)
+	player.Wallets["tickets"] = 90
+	store.SavePlayer(player, nil)
+	report, err := Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation of the ledger, got", err)
+	test(t, len(report.Mismatches) == 1 && report.Mismatches[0].Account == Wallet(Player("p1"), "tickets"),
+		"Expected mismatch of the player tickets, got", report.Mismatches)
+}
+
+func TestCredits(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	store.NewPlayer("p2", nil)
+	defer func() { FundExpiry = 0 }()
+
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	player.CreditLimit = 100
+	store.SavePlayer(player, nil)
+	err = post(store, New(Take, Player("p1"), House, 80))
+	test(t, err == ErrInsufficientPoints, "Expected credit is not taken, got", err)
+	err = post(store, New(Transfer, Player("p1"), Player("p2"), 80))
+	test(t, err == ErrInsufficientPoints, "Expected credit is not transferred, got", err)
+	err = post(store, Collect(Contribution, Escrow(1), 120, Player("p1")))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = post(store, Collect(Contribution, Escrow(1), 80, Player("p1")))
+	test(t, err == nil, "Expected contribution on credit, got", err)
+	err = post(store, Collect(Contribution, Escrow(1), 10, Player("p2")))
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+
+	credits, total, err := Credits(store, nil)
+	test(t, err == nil, "Expected credits of the players, got", err)
+	test(t, len(credits) == 1 && credits[0] == Credit{Player: "p1", Limit: 100, Outstanding: 80},
+		"Expected 80 points of outstanding credit, got", credits)
+	test(t, total == 80, "Expected 80 points of total outstanding credit, got", total)
+
+	FundExpiry = time.Hour
+	err = post(store, New(Fund, House, Player("p1"), 100))
+	test(t, err == nil, "Expected post of the operation, got", err)
+	player, err = store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	lots, err := store.FindLots("p1", nil)
+	test(t, err == nil && player.Balance == 20 && len(lots) == 1 && lots[0].Amount == 20,
+		"Expected 20 points in the lot after repayment of the credit, got", player.Balance, lots, err)
+	_, total, err = Credits(store, nil)
+	test(t, err == nil, "Expected credits of the players, got", err)
+	test(t, total == 0, "Expected repaid credit, got", total)
+}
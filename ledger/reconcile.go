@@ -0,0 +1,161 @@
+package ledger
+
+import (
+	"sort"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Report contains results of the ledger reconciliation
+type Report struct {
+	// Accounts contains balances of all accounts summed up from the ledger,
+	// balances in other currencies than the default points are keyed by Wallet
+	Accounts map[string]backer.Points
+	// Mismatches contains accounts whose stored balance differs from the ledger
+	Mismatches []Mismatch
+	// Unbalanced contains IDs of the operations whose postings do not sum up to zero
+	Unbalanced []string
+}
+
+// Mismatch describes the account whose stored balance differs from the ledger,
+// escrow accounts of finished tournaments and accounts of removed players are expected to be empty
+type Mismatch struct {
+	Account string
+	Balance backer.Points
+	Ledger  backer.Points
+}
+
+// Reconciled reports whether the ledger matches stored balances
+func (report *Report) Reconciled() bool {
+	return len(report.Mismatches) == 0 && len(report.Unbalanced) == 0
+}
+
+// Reconcile checks all operations of the ledger and compares stored balances
+// of the players including archived ones against balances of their accounts in every currency,
+// non-zero accounts and wallets of the players which have no records are reported as mismatches
+func Reconcile(ctrl datastore.Controller, tx datastore.Transact) (*Report, error) {
+	report := &Report{Accounts: make(map[string]backer.Points)}
+	currencies := map[string]bool{"": true}
+	// owners keeps players of the accounts and wallets found in the ledger
+	owners := make(map[string]string)
+	query := datastore.OperationQuery{}
+	for {
+		operations, next, err := ctrl.ListOperations(query, tx)
+		if err != nil {
+			return nil, err
+		}
+		for _, operation := range operations {
+			var sum backer.Points
+			currencies[operation.Currency] = true
+			for _, posting := range operation.Postings {
+				sum += posting.Amount
+				account := Wallet(posting.Account, operation.Currency)
+				report.Accounts[account] = round(report.Accounts[account] + posting.Amount)
+				if ID, ok := PlayerOf(posting.Account); ok {
+					owners[account] = ID
+				}
+			}
+			if round(sum) != 0 {
+				report.Unbalanced = append(report.Unbalanced, operation.ID)
+			}
+		}
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	players := make(map[string]bool)
+	for _, archived := range []bool{false, true} {
+		err := eachPlayer(ctrl, tx, archived, func(player model.Player) {
+			players[player.ID] = true
+			for currency := range player.Wallets {
+				currencies[currency] = true
+			}
+			for _, currency := range sortedCurrencies(currencies) {
+				account := Wallet(Player(player.ID), currency)
+				balance := player.Balance
+				if currency != "" {
+					balance = player.Wallets[currency]
+				}
+				if report.Accounts[account] != balance {
+					report.Mismatches = append(report.Mismatches, Mismatch{
+						Account: account, Balance: balance, Ledger: report.Accounts[account],
+					})
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	orphans := make([]string, 0)
+	for account, ID := range owners {
+		if !players[ID] && report.Accounts[account] != 0 {
+			orphans = append(orphans, account)
+		}
+	}
+	sort.Strings(orphans)
+	for _, account := range orphans {
+		report.Mismatches = append(report.Mismatches, Mismatch{Account: account, Ledger: report.Accounts[account]})
+	}
+	for _, archived := range []bool{false, true} {
+		err := eachTournament(ctrl, tx, archived, func(tournament model.Tournament) {
+			account := Wallet(Escrow(tournament.ID), tournament.Currency)
+			if tournament.IsFinished && report.Accounts[account] != 0 {
+				report.Mismatches = append(report.Mismatches, Mismatch{
+					Account: account, Ledger: report.Accounts[account],
+				})
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func eachPlayer(ctrl datastore.Controller, tx datastore.Transact, archived bool, fn func(model.Player)) error {
+	query := datastore.PlayerQuery{Archived: archived}
+	for {
+		players, next, err := ctrl.ListPlayers(query, tx)
+		if err != nil {
+			return err
+		}
+		for _, player := range players {
+			fn(player)
+		}
+		if next == "" {
+			return nil
+		}
+		query.Cursor = next
+	}
+}
+
+func eachTournament(ctrl datastore.Controller, tx datastore.Transact, archived bool, fn func(model.Tournament)) error {
+	query := datastore.TournamentQuery{Archived: archived}
+	for {
+		tournaments, next, err := ctrl.ListTournaments(query, tx)
+		if err != nil {
+			return err
+		}
+		for _, tournament := range tournaments {
+			fn(tournament)
+		}
+		if next == "" {
+			return nil
+		}
+		query.Cursor = next
+	}
+}
+
+// sortedCurrencies returns currencies in the stable order
+func sortedCurrencies(currencies map[string]bool) []string {
+	keys := make([]string, 0, len(currencies))
+	for currency := range currencies {
+		keys = append(keys, currency)
+	}
+	sort.Strings(keys)
+	return keys
+}
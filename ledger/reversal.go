@@ -0,0 +1,105 @@
+package ledger
+
+import (
+	"errors"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrAlreadyReversed appears if the operation was already reversed
+	ErrAlreadyReversed = errors.New("Operation already reversed")
+	// ErrOpenTournament appears if the contribution to the tournament which is not finished is reversed
+	ErrOpenTournament = errors.New("Contribution to the open tournament could not be reversed")
+)
+
+// Policy defines how the reversal handles players with insufficient points
+type Policy int
+
+const (
+	// FailInsufficient rejects the reversal if a player has insufficient points
+	FailInsufficient Policy = iota
+	// AllowNegative takes points back even if the player balance becomes negative
+	AllowNegative
+	// PartialClawback takes back available points only, the house covers the rest
+	PartialClawback
+)
+
+// ReversalOf returns ID of the operation which reverses the operation with specified ID
+func ReversalOf(ID string) string {
+	return "reversal:" + ID
+}
+
+// Reverse posts the operation which compensates movements of the operation with specified ID,
+// the actor who reversed the operation and the reason are kept in the reversal.
+// The escrow of the finished tournament is already settled with the house,
+// so the house is used instead of it. Contributions to the open tournament are not reversed
+// while the players are its bidders. The operation could be reversed only once
+func Reverse(ctrl datastore.Controller, tx datastore.Transact,
+	ID, actor, reason string, policy Policy) (*model.Operation, error) {
+	original, err := ctrl.FindOperation(ID, tx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := ctrl.FindOperation(ReversalOf(ID), tx); err == nil {
+		return nil, ErrAlreadyReversed
+	} else if err != datastore.ErrRecordNotFound {
+		return nil, err
+	}
+	settled := false
+	if original.Tournament != 0 {
+		tournament, err := ctrl.FindTournament(original.Tournament, tx)
+		if err != nil && err != datastore.ErrRecordNotFound {
+			return nil, err
+		}
+		settled = err != nil || tournament.IsFinished
+		if !settled && original.Kind == Contribution {
+			return nil, ErrOpenTournament
+		}
+	}
+	reversal := &model.Operation{
+		ID:         ReversalOf(ID),
+		Kind:       Reversal,
+		Currency:   original.Currency,
+		Tournament: original.Tournament,
+		Memo:       reason,
+		Actor:      actor,
+		Reverses:   ID,
+	}
+	var shortfall backer.Points
+	for _, posting := range original.Postings {
+		account, amount := posting.Account, -posting.Amount
+		if settled && account == Escrow(original.Tournament) {
+			account = House
+		}
+		if ID, ok := PlayerOf(account); ok && amount < 0 && policy == PartialClawback {
+			player, err := ctrl.FindPlayerForUpdate(ID, tx)
+			if err != nil {
+				return nil, err
+			}
+			available := Spendable(player, original.Currency, time.Now())
+			if available < 0 {
+				available = 0
+			}
+			if available < -amount {
+				shortfall = round(shortfall - amount - available)
+				amount = -available
+			}
+		}
+		reversal.Postings = append(reversal.Postings, model.Posting{Account: account, Amount: amount})
+	}
+	if shortfall != 0 {
+		reversal.Postings = append(reversal.Postings, model.Posting{Account: House, Amount: -shortfall})
+	}
+	err = record(ctrl, tx, reversal, policy == AllowNegative)
+	if err == datastore.ErrAlreadyExist {
+		return nil, ErrAlreadyReversed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return reversal, nil
+}
@@ -0,0 +1,70 @@
+package ledger
+
+import (
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Movement is a change of the player balance made by the ledger operation,
+// the kind and memo of the operation explain the reason of the change
+type Movement struct {
+	Operation      string        `json:"operation"`
+	Created        time.Time     `json:"created"`
+	Kind           string        `json:"kind"`
+	Currency       string        `json:"currency,omitempty"`
+	Memo           string        `json:"memo,omitempty"`
+	Tournament     uint64        `json:"tournament,omitempty"`
+	Amount         backer.Points `json:"amount"`
+	Balance        backer.Points `json:"balance"`
+	Counterparties []string      `json:"counterparties"`
+}
+
+// Statement returns a page of the player movements created within the date range and the next page cursor,
+// zero dates are not applied, the date range includes from and excludes to
+func Statement(ctrl datastore.Controller, tx datastore.Transact,
+	ID string, from, to time.Time, page datastore.Page) ([]Movement, string, error) {
+	account := Player(ID)
+	operations, next, err := ctrl.ListOperations(datastore.OperationQuery{
+		Account: account,
+		From:    from,
+		To:      to,
+		Page:    page,
+	}, tx)
+	if err != nil {
+		return nil, "", err
+	}
+	movements := make([]Movement, 0, len(operations))
+	for _, operation := range operations {
+		for _, posting := range operation.Postings {
+			if posting.Account == account {
+				movements = append(movements, movement(&operation, posting))
+			}
+		}
+	}
+	return movements, next, nil
+}
+
+// movement returns the movement of the posting, the counterparties are
+// the accounts which are changed by the operation in the opposite direction
+func movement(operation *model.Operation, posting model.Posting) Movement {
+	result := Movement{
+		Operation:      operation.ID,
+		Created:        operation.Created,
+		Kind:           operation.Kind,
+		Currency:       operation.Currency,
+		Memo:           operation.Memo,
+		Tournament:     operation.Tournament,
+		Amount:         posting.Amount,
+		Balance:        posting.Balance,
+		Counterparties: make([]string, 0),
+	}
+	for _, other := range operation.Postings {
+		if other.Amount < 0 && posting.Amount > 0 || other.Amount > 0 && posting.Amount < 0 {
+			result.Counterparties = append(result.Counterparties, other.Account)
+		}
+	}
+	return result
+}
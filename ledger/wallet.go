@@ -0,0 +1,77 @@
+package ledger
+
+import (
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Wallet returns the key of the account balance in the currency,
+// it is the account itself for the default points
+func Wallet(account, currency string) string {
+	if currency == "" {
+		return account
+	}
+	return account + "@" + currency
+}
+
+// Spendable returns points of the player in the currency which could be spent at the moment,
+// holds are applied to the default points only
+func Spendable(player *model.Player, currency string, now time.Time) backer.Points {
+	if currency == "" {
+		return Available(player, now)
+	}
+	return player.Wallets[currency]
+}
+
+// Funded returns points of the player in the currency which could be spent without the credit line,
+// the credit line is not used by takes, transfers and holds, so it could not be withdrawn or passed to other players
+func Funded(player *model.Player, currency string, now time.Time) backer.Points {
+	if currency == "" {
+		return round(Available(player, now) - player.CreditLimit)
+	}
+	return player.Wallets[currency]
+}
+
+// BalanceIn returns the balance of the account in the currency summed up from its postings
+func BalanceIn(ctrl datastore.Controller, tx datastore.Transact,
+	account, currency string) (backer.Points, error) {
+	var balance backer.Points
+	query := datastore.OperationQuery{Account: account}
+	for {
+		operations, next, err := ctrl.ListOperations(query, tx)
+		if err != nil {
+			return 0, err
+		}
+		for _, operation := range operations {
+			if operation.Currency != currency {
+				continue
+			}
+			for _, posting := range operation.Postings {
+				if posting.Account == account {
+					balance = round(balance + posting.Amount)
+				}
+			}
+		}
+		if next == "" {
+			return balance, nil
+		}
+		query.Cursor = next
+	}
+}
+
+// credit applies the posting amount to the player balance in the currency of the operation
+// and returns the resulting balance
+func credit(player *model.Player, operation *model.Operation, amount backer.Points) backer.Points {
+	if operation.Currency != "" {
+		if player.Wallets == nil {
+			player.Wallets = make(map[string]backer.Points)
+		}
+		player.Wallets[operation.Currency] = round(player.Wallets[operation.Currency] + amount)
+		return player.Wallets[operation.Currency]
+	}
+	player.Balance = round(player.Balance + amount)
+	return player.Balance
+}
@@ -0,0 +1,264 @@
+// Package limits enforces responsible gaming limits of the points which the players
+// spend on own entries and takes and stake on other players as backers
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/helper"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrSelfExcluded appears if the player excluded from tournaments joins a tournament
+	ErrSelfExcluded = errors.New("Player is excluded from tournaments")
+)
+
+// Categories of the limits
+const (
+	// Spend limits points taken from the player, transferred to other players and contributed to own tournament entries
+	Spend = "spend"
+	// Stake limits points contributed to entries of other players as a backer
+	Stake = "stake"
+)
+
+// Periods of the limits, the periods are calendar day, week starting on Monday and month
+const (
+	Daily   = "daily"
+	Weekly  = "weekly"
+	Monthly = "monthly"
+)
+
+// Defaults defines limits of the players which have no own limits, zero limits are not applied
+var Defaults model.Limits
+
+// CoolingOff defines the delay before looser own limits of the player take effect
+var CoolingOff = 24 * time.Hour
+
+// Error appears if the player exceeds the limit of the category within the period,
+// the limit is available again when the period resets
+type Error struct {
+	Category string
+	Period   string
+	Limit    backer.Points
+	Used     backer.Points
+	Amount   backer.Points
+	Resets   time.Time
+}
+
+// Error implements error interface
+func (e *Error) Error() string {
+	return fmt.Sprintf("The %s %s limit %v is exceeded by %v points (%v already used), it resets at %s",
+		e.Period, e.Category, e.Limit, e.Amount, e.Used, e.Resets.Format(time.RFC3339))
+}
+
+// IsLimit reports whether an error is a limit error
+func IsLimit(err error) bool {
+	_, ok := err.(*Error)
+	return ok
+}
+
+// Of returns limits of the player at the moment, the limits which are not set for the player
+// are taken from Defaults
+func Of(player *model.Player, now time.Time) model.Limits {
+	own := ownOf(player, now)
+	limits := Defaults
+	limits.Spend = merge(own.Spend, limits.Spend)
+	limits.Stake = merge(own.Stake, limits.Stake)
+	return limits
+}
+
+// Set changes own limits of the player, stricter limits take effect at once
+// and looser limits take effect after CoolingOff, the current limits are applied until then
+func Set(player *model.Player, own model.Limits, now time.Time) {
+	current, effective := ownOf(player, now), Of(player, now)
+	spend, looserSpend := stricter(own.Spend, current.Spend, merge(own.Spend, Defaults.Spend), effective.Spend)
+	stake, looserStake := stricter(own.Stake, current.Stake, merge(own.Stake, Defaults.Stake), effective.Stake)
+	player.Limits = &model.Limits{Spend: spend, Stake: stake}
+	player.PendingLimits = nil
+	if looserSpend || looserStake {
+		player.PendingLimits = &model.PendingLimits{Limits: own, Effective: now.Add(CoolingOff)}
+	}
+}
+
+// ownOf returns own limits of the player at the moment including pending limits which took effect
+func ownOf(player *model.Player, now time.Time) model.Limits {
+	if player.PendingLimits != nil && !now.Before(player.PendingLimits.Effective) {
+		return player.PendingLimits.Limits
+	}
+	if player.Limits != nil {
+		return *player.Limits
+	}
+	return model.Limits{}
+}
+
+// stricter returns own periods where the limits which are looser than the effective ones
+// are replaced by the current own limits, looser reports whether any of them was replaced
+func stricter(own, current, next, effective model.Periods) (model.Periods, bool) {
+	looser := false
+	keep := func(own, current, next, effective backer.Points) backer.Points {
+		if effective > 0 && (next == 0 || next > effective) {
+			looser = true
+			return current
+		}
+		return own
+	}
+	own.Daily = keep(own.Daily, current.Daily, next.Daily, effective.Daily)
+	own.Weekly = keep(own.Weekly, current.Weekly, next.Weekly, effective.Weekly)
+	own.Monthly = keep(own.Monthly, current.Monthly, next.Monthly, effective.Monthly)
+	return own, looser
+}
+
+// Used returns points of the player spent and staked within the current periods,
+// reversed operations are not counted
+func Used(ctrl datastore.Controller, tx datastore.Transact, ID string, now time.Time) (model.Limits, error) {
+	var used model.Limits
+	account := ledger.Player(ID)
+	day, week, month := starts(now)
+	from := month
+	if week.Before(from) {
+		from = week
+	}
+	// reversals are created after their operations, so they are listed within the same periods
+	operations := make([]model.Operation, 0)
+	reversed := make(map[string]bool)
+	query := datastore.OperationQuery{Account: account, From: from}
+	for {
+		page, next, err := ctrl.ListOperations(query, tx)
+		if err != nil {
+			return used, err
+		}
+		for _, operation := range page {
+			if operation.Reverses != "" {
+				reversed[operation.Reverses] = true
+			}
+		}
+		operations = append(operations, page...)
+		if next == "" {
+			break
+		}
+		query.Cursor = next
+	}
+	for _, operation := range operations {
+		periods := periodsOf(&operation, account, &used)
+		if periods == nil || reversed[operation.ID] {
+			continue
+		}
+		for _, posting := range operation.Postings {
+			if posting.Account != account || posting.Amount >= 0 {
+				continue
+			}
+			if !operation.Created.Before(day) {
+				periods.Daily = round(periods.Daily - posting.Amount)
+			}
+			if !operation.Created.Before(week) {
+				periods.Weekly = round(periods.Weekly - posting.Amount)
+			}
+			if !operation.Created.Before(month) {
+				periods.Monthly = round(periods.Monthly - posting.Amount)
+			}
+		}
+	}
+	return used, nil
+}
+
+// Check returns an error if the amount of the category exceeds the limits of the player
+// within the current periods, only the default points are limited
+func Check(ctrl datastore.Controller, tx datastore.Transact,
+	player *model.Player, category string, amount backer.Points, now time.Time) error {
+	limits := Of(player, now)
+	periods := limits.Spend
+	if category == Stake {
+		periods = limits.Stake
+	}
+	if periods == (model.Periods{}) {
+		return nil
+	}
+	used, err := Used(ctrl, tx, player.ID, now)
+	if err != nil {
+		return err
+	}
+	usage := used.Spend
+	if category == Stake {
+		usage = used.Stake
+	}
+	day, week, month := starts(now)
+	checks := []struct {
+		period string
+		limit  backer.Points
+		used   backer.Points
+		resets time.Time
+	}{
+		{Daily, periods.Daily, usage.Daily, day.AddDate(0, 0, 1)},
+		{Weekly, periods.Weekly, usage.Weekly, week.AddDate(0, 0, 7)},
+		{Monthly, periods.Monthly, usage.Monthly, month.AddDate(0, 1, 0)},
+	}
+	for _, check := range checks {
+		if check.limit > 0 && round(check.used+amount) > check.limit {
+			return &Error{
+				Category: category,
+				Period:   check.period,
+				Limit:    check.limit,
+				Used:     round(check.used),
+				Amount:   amount,
+				Resets:   check.resets,
+			}
+		}
+	}
+	return nil
+}
+
+// Excluded reports whether the player is excluded from tournaments at the moment
+func Excluded(player *model.Player, now time.Time) bool {
+	return player.Exclusion != nil && !now.Before(player.Exclusion.Start) && now.Before(player.Exclusion.End)
+}
+
+// periodsOf returns usage periods of the operation category or nil if the operation is not limited,
+// the first posting of the contribution belongs to the bidder and the rest belong to the backers
+func periodsOf(operation *model.Operation, account string, used *model.Limits) *model.Periods {
+	if operation.Currency != "" {
+		return nil
+	}
+	switch operation.Kind {
+	case ledger.Take, ledger.Transfer:
+		return &used.Spend
+	case ledger.Contribution:
+		if len(operation.Postings) > 0 && operation.Postings[0].Account == account {
+			return &used.Spend
+		}
+		return &used.Stake
+	}
+	return nil
+}
+
+// starts returns starts of the calendar day, week and month of the moment
+func starts(now time.Time) (day, week, month time.Time) {
+	year, mon, date := now.Date()
+	day = time.Date(year, mon, date, 0, 0, 0, 0, now.Location())
+	week = day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	month = time.Date(year, mon, 1, 0, 0, 0, 0, now.Location())
+	return day, week, month
+}
+
+// merge returns the limits of the periods, unset limits are taken from the defaults
+func merge(periods, defaults model.Periods) model.Periods {
+	if periods.Daily == 0 {
+		periods.Daily = defaults.Daily
+	}
+	if periods.Weekly == 0 {
+		periods.Weekly = defaults.Weekly
+	}
+	if periods.Monthly == 0 {
+		periods.Monthly = defaults.Monthly
+	}
+	return periods
+}
+
+func round(amount backer.Points) backer.Points {
+	return backer.Points(helper.RoundPrice(float32(amount)))
+}
@@ -0,0 +1,138 @@
+package limits
+
+import (
+	"testing"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/model"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func TestStarts(t *testing.T) {
+
+	now := time.Date(2018, time.March, 1, 15, 4, 5, 0, time.UTC)
+	day, week, month := starts(now)
+	test(t, day.Equal(time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC)), "Expected start of the day, got", day)
+	test(t, week.Equal(time.Date(2018, time.February, 26, 0, 0, 0, 0, time.UTC)), "Expected Monday, got", week)
+	test(t, month.Equal(time.Date(2018, time.March, 1, 0, 0, 0, 0, time.UTC)), "Expected start of the month, got", month)
+	_, week, _ = starts(time.Date(2018, time.March, 4, 23, 0, 0, 0, time.UTC))
+	test(t, week.Equal(time.Date(2018, time.February, 26, 0, 0, 0, 0, time.UTC)), "Expected Monday for Sunday, got", week)
+}
+
+func TestCheck(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	defer func() { Defaults = model.Limits{} }()
+	Defaults = model.Limits{Spend: model.Periods{Daily: 100, Monthly: 1000}}
+	for _, id := range []string{"p1", "b1"} {
+		store.NewPlayer(id, nil)
+		err := datastore.WithTransaction(store, func(tx datastore.Transact) error {
+			return ledger.Post(store, tx, ledger.New(ledger.Fund, ledger.House, ledger.Player(id), 500))
+		})
+		test(t, err == nil, "Expected fund of the player, got", err)
+	}
+	err := datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		operation := ledger.Collect(ledger.Contribution, ledger.Escrow(1), 60, ledger.Player("p1"), ledger.Player("b1"))
+		return ledger.Post(store, tx, operation)
+	})
+	test(t, err == nil, "Expected contribution of the players, got", err)
+
+	used, err := Used(store, nil, "p1", time.Now())
+	test(t, err == nil, "Expected used points of the player, got", err)
+	test(t, used.Spend.Daily == 60 && used.Spend.Monthly == 60 && used.Stake == model.Periods{},
+		"Expected 60 spent points, got", used)
+	used, err = Used(store, nil, "b1", time.Now())
+	test(t, err == nil, "Expected used points of the player, got", err)
+	test(t, used.Stake.Daily == 60 && used.Spend == model.Periods{}, "Expected 60 staked points, got", used)
+
+	player, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	err = Check(store, nil, player, Spend, 40, time.Now())
+	test(t, err == nil, "Expected spending within the limits, got", err)
+	err = Check(store, nil, player, Spend, 41, time.Now())
+	test(t, IsLimit(err), "Expected limit error, got", err)
+	if limit, ok := err.(*Error); ok {
+		day, _, _ := starts(time.Now())
+		test(t, limit.Category == Spend && limit.Period == Daily && limit.Limit == 100 && limit.Used == 60,
+			"Expected daily spending limit, got", limit)
+		test(t, limit.Resets.Equal(day.AddDate(0, 0, 1)), "Expected reset tomorrow, got", limit.Resets)
+		test(t, limit.Error() != "", "Expected description of the limit")
+	}
+	err = Check(store, nil, player, Stake, 1000, time.Now())
+	test(t, err == nil, "Expected no stake limits, got", err)
+
+	player.Limits = &model.Limits{Spend: model.Periods{Daily: 500}, Stake: model.Periods{Weekly: 10}}
+	limits := Of(player, time.Now())
+	test(t, limits.Spend.Daily == 500 && limits.Spend.Monthly == 1000 && limits.Stake.Weekly == 10,
+		"Expected own limits merged with defaults, got", limits)
+	err = Check(store, nil, player, Spend, 400, time.Now())
+	test(t, err == nil, "Expected spending within own limits, got", err)
+	err = Check(store, nil, player, Stake, 11, time.Now())
+	if limit, ok := err.(*Error); ok {
+		test(t, limit.Category == Stake && limit.Period == Weekly, "Expected weekly stake limit, got", limit)
+	} else {
+		t.Error("Expected limit error, got", err)
+	}
+}
+
+func TestUsedReversals(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	store.NewPlayer("p1", nil)
+	take := ledger.New(ledger.Take, ledger.Player("p1"), ledger.House, 70)
+	err := datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		if err := ledger.Post(store, tx, ledger.New(ledger.Fund, ledger.House, ledger.Player("p1"), 500)); err != nil {
+			return err
+		}
+		return ledger.Post(store, tx, take)
+	})
+	test(t, err == nil, "Expected fund and take of the player, got", err)
+	used, err := Used(store, nil, "p1", time.Now())
+	test(t, err == nil && used.Spend.Daily == 70, "Expected 70 spent points, got", used, err)
+
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		_, err := ledger.Reverse(store, tx, take.ID, "admin", "mistake", ledger.FailInsufficient)
+		return err
+	})
+	test(t, err == nil, "Expected reversal of the take, got", err)
+	used, err = Used(store, nil, "p1", time.Now())
+	test(t, err == nil && used.Spend == model.Periods{}, "Expected no spent points after the reversal, got", used, err)
+}
+
+func TestSet(t *testing.T) {
+
+	defer func() { Defaults = model.Limits{} }()
+	Defaults = model.Limits{Spend: model.Periods{Monthly: 1000}}
+	now := time.Now()
+	player := new(model.Player)
+	Set(player, model.Limits{Spend: model.Periods{Daily: 100}}, now)
+	limits := Of(player, now)
+	test(t, limits.Spend.Daily == 100 && limits.Spend.Monthly == 1000 && player.PendingLimits == nil,
+		"Expected stricter limits at once, got", limits, player.PendingLimits)
+
+	Set(player, model.Limits{Spend: model.Periods{Daily: 200, Weekly: 300}}, now)
+	limits = Of(player, now)
+	test(t, limits.Spend.Daily == 100 && limits.Spend.Weekly == 300, "Expected looser limit is pending, got", limits)
+	test(t, player.PendingLimits != nil && player.PendingLimits.Effective.Equal(now.Add(CoolingOff)),
+		"Expected pending limits after the cooling-off, got", player.PendingLimits)
+	limits = Of(player, now.Add(CoolingOff))
+	test(t, limits.Spend.Daily == 200 && limits.Spend.Weekly == 300,
+		"Expected looser limit after the cooling-off, got", limits)
+
+	later := now.Add(CoolingOff)
+	Set(player, model.Limits{Spend: model.Periods{Daily: 50}}, later)
+	limits = Of(player, later)
+	test(t, limits.Spend.Daily == 50 && limits.Spend.Weekly == 300, "Expected removed limit is pending, got", limits)
+	limits = Of(player, later.Add(CoolingOff))
+	test(t, limits.Spend.Daily == 50 && limits.Spend.Weekly == 0 && limits.Spend.Monthly == 1000,
+		"Expected removed limit after the cooling-off, got", limits)
+}
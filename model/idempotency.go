@@ -0,0 +1,13 @@
+package model
+
+import (
+	"time"
+)
+
+// Idempotency data model keeps the outcome of the request done with the idempotency key
+type Idempotency struct {
+	Key     string    `json:"key"`
+	Request string    `json:"request"`
+	Outcome string    `json:"outcome"`
+	Created time.Time `json:"created"`
+}
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"time"
+
+	"github.com/takama/backer"
+)
+
+// Operation data model of the ledger,
+// amounts of the operation postings are balanced and sum up to zero,
+// the actor is who made the operation if it was made manually
+// and reverses contains ID of the operation which is compensated,
+// the empty currency means the default points
+type Operation struct {
+	ID         string    `json:"id"`
+	Kind       string    `json:"kind"`
+	Currency   string    `json:"currency,omitempty"`
+	Tournament uint64    `json:"tournament,omitempty"`
+	Memo       string    `json:"memo,omitempty"`
+	Actor      string    `json:"actor,omitempty"`
+	Reverses   string    `json:"reverses,omitempty"`
+	Created    time.Time `json:"created"`
+	Postings   []Posting `json:"postings"`
+}
+
+// Posting data model, positive amount is credited to the account
+// and negative amount is debited from the account,
+// the resulting balance is kept for player accounts
+type Posting struct {
+	Account string        `json:"account"`
+	Amount  backer.Points `json:"amount"`
+	Balance backer.Points `json:"balance,omitempty"`
+}
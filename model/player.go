@@ -1,11 +1,111 @@
 package model
 
 import (
+	"time"
+
 	"github.com/takama/backer"
 )
 
-// Player data model
+// Player data model, audit entries, statistics and lots of the player are kept as separate records
 type Player struct {
+	ID       string        `json:"id"`
+	Version  uint64        `json:"version"`
+	Balance  backer.Points `json:"balance"`
+	Archived bool          `json:"archived"`
+	Holds    []Hold        `json:"holds,omitempty"`
+	// Wallets contains balances of the player in other currencies than the default points
+	Wallets map[string]backer.Points `json:"wallets,omitempty"`
+	// Status of the account is active if it is empty, credits are rejected as well
+	// on the suspended account if they are frozen, the audit keeps changes of the account
+	Status        Status `json:"status,omitempty"`
+	StatusReason  string `json:"status_reason,omitempty"`
+	FrozenCredits bool   `json:"frozen_credits,omitempty"`
+	// Limits overrides default spending and backing limits of the player,
+	// looser limits are pending until they take effect after the cooling-off period
+	Limits        *Limits        `json:"limits,omitempty"`
+	PendingLimits *PendingLimits `json:"pending_limits,omitempty"`
+	// Exclusion keeps the player out of tournaments within the period
+	Exclusion *Exclusion `json:"exclusion,omitempty"`
+	// CreditLimit allows the balance of the trusted player to become negative up to the limit
+	CreditLimit backer.Points `json:"credit_limit,omitempty"`
+}
+
+// Hold data model earmarks points of the player balance until it expires
+type Hold struct {
 	ID      string        `json:"id"`
-	Balance backer.Points `json:"balance"`
+	Amount  backer.Points `json:"amount"`
+	Created time.Time     `json:"created"`
+	Expires time.Time     `json:"expires"`
+}
+
+// Lot data model keeps funded points of the player which expire at the date unless they are spent before
+type Lot struct {
+	Operation string        `json:"operation"`
+	Amount    backer.Points `json:"amount"`
+	Created   time.Time     `json:"created"`
+	Expires   time.Time     `json:"expires"`
+}
+
+// Status of the player account
+type Status string
+
+// Statuses of the player account
+const (
+	// Active account could be funded and spent
+	Active Status = "active"
+	// Suspended account could not be spent while it is under investigation
+	Suspended Status = "suspended"
+	// Closed account could not be changed anymore
+	Closed Status = "closed"
+)
+
+// AuditEntry data model keeps the change of the player account made by the actor with the reason
+type AuditEntry struct {
+	Action  string    `json:"action"`
+	Status  Status    `json:"status,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+	Actor   string    `json:"actor"`
+	Created time.Time `json:"created"`
+}
+
+// Limits data model restricts points which the player spends on own entries and takes (Spend)
+// and stakes on other players as a backer (Stake)
+type Limits struct {
+	Spend Periods `json:"spend"`
+	Stake Periods `json:"stake"`
+}
+
+// PendingLimits data model keeps looser limits of the player until they take effect
+type PendingLimits struct {
+	Limits    Limits    `json:"limits"`
+	Effective time.Time `json:"effective"`
+}
+
+// Periods data model contains limits per calendar day, week and month, zero limits are not applied
+type Periods struct {
+	Daily   backer.Points `json:"daily,omitempty"`
+	Weekly  backer.Points `json:"weekly,omitempty"`
+	Monthly backer.Points `json:"monthly,omitempty"`
+}
+
+// Exclusion data model defines the period when the player is excluded from tournaments
+type Exclusion struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// Stats data model contains performance of the player as a bidder and as a backer
+type Stats struct {
+	Bidder Performance `json:"bidder"`
+	Backer Performance `json:"backer"`
+}
+
+// Performance data model contains the number of entries and cashed entries,
+// points staked on the entries, returned by prizes and the biggest prize
+type Performance struct {
+	Entries    int           `json:"entries"`
+	Cashes     int           `json:"cashes"`
+	Staked     backer.Points `json:"staked"`
+	Returned   backer.Points `json:"returned"`
+	BiggestWin backer.Points `json:"biggest_win"`
 }
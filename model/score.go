@@ -0,0 +1,10 @@
+package model
+
+// Score data model keeps the value of the member in the board,
+// the rank starts from 1 and members with equal values share the same rank
+type Score struct {
+	Board  string  `json:"board"`
+	Member string  `json:"member"`
+	Value  float64 `json:"value"`
+	Rank   int     `json:"rank"`
+}
@@ -1,21 +1,37 @@
 package model
 
 import (
+	"time"
+
 	"github.com/takama/backer"
 )
 
 // Tournament data model
 type Tournament struct {
-	ID         uint64        `json:"id"`
-	Deposit    backer.Points `json:"deposit"`
-	IsFinished bool          `json:"is_finished"`
-	Bidders    []Bidder      `json:"bidders"`
+	ID          uint64        `json:"id"`
+	Version     uint64        `json:"version"`
+	Deposit     backer.Points `json:"deposit"`
+	Currency    string        `json:"currency,omitempty"`
+	IsFinished  bool          `json:"is_finished"`
+	Archived    bool          `json:"archived"`
+	Created     time.Time     `json:"created"`
+	Finished    time.Time     `json:"finished"`
+	Bidders     []Bidder      `json:"bidders"`
+	BackerRules *BackerRules  `json:"backer_rules,omitempty"`
+}
+
+// BackerRules data model of the backer relationship rules kept with the tournament
+type BackerRules struct {
+	SingleBidder bool        `json:"single_bidder"`
+	Blocklist    [][2]string `json:"blocklist,omitempty"`
 }
 
-// Bidder data model
+// Bidder data model, withheld players could not be credited with their prize shares
+// which are settled with the house for manual settlement
 type Bidder struct {
-	ID      string        `json:"id"`
-	Winner  bool          `json:"winner"`
-	Prize   backer.Points `json:"prize"`
-	Backers []string      `json:"backers"`
+	ID       string        `json:"id"`
+	Winner   bool          `json:"winner"`
+	Prize    backer.Points `json:"prize"`
+	Backers  []string      `json:"backers"`
+	Withheld []string      `json:"withheld,omitempty"`
 }
@@ -0,0 +1,50 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrInvalidCreditLimit appears if the credit limit is negative
+	ErrInvalidCreditLimit = errors.New("Credit limit could not be negative")
+)
+
+// SetCreditLimit allows the balance of the trusted player to become negative up to the limit,
+// outstanding credit is repaid by the following credits like tournament prizes,
+// the actor who set the limit is kept in the audit
+func (entry *Entry) SetCreditLimit(actor string, limit backer.Points) error {
+	return entry.SetCreditLimitContext(context.Background(), actor, limit)
+}
+
+// SetCreditLimitContext sets the credit limit of the player using the context
+func (entry *Entry) SetCreditLimitContext(ctx context.Context, actor string, limit backer.Points) error {
+	if limit < 0 {
+		return ErrInvalidCreditLimit
+	}
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Status == model.Closed {
+			return ErrAccountClosed
+		}
+		player.CreditLimit = limit
+		if err := entry.Controller.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		return entry.Controller.NewAuditEntry(player.ID, &model.AuditEntry{
+			Action:  "credit limit",
+			Reason:  fmt.Sprintf("credit limit %v", limit),
+			Actor:   actor,
+			Created: time.Now(),
+		}, tx)
+	})
+}
@@ -0,0 +1,122 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/limits"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrInvalidExclusion appears if the exclusion does not end in the future after it starts
+	ErrInvalidExclusion = errors.New("Exclusion should end in the future after it starts")
+	// ErrExclusionActive appears if the player shortens or postpones the active or scheduled exclusion
+	ErrExclusionActive = errors.New("Active or scheduled exclusion could not be shortened")
+	// ErrNotExcluded appears if the exclusion of the player which is not excluded is lifted
+	ErrNotExcluded = errors.New("Player is not excluded")
+	// ErrNotAdmin appears if the actor which is not an admin lifts the exclusion
+	ErrNotAdmin = errors.New("Only an admin could lift the exclusion")
+	// ErrSelfExcluded appears if the player excluded from tournaments joins a tournament
+	ErrSelfExcluded = limits.ErrSelfExcluded
+)
+
+// Admin reports whether the actor is an admin who is allowed to lift exclusions of the players,
+// the actor is authenticated by the caller, no actor is an admin until it is set
+var Admin func(ctx context.Context, actor string) bool
+
+// Exclude excludes the player from tournaments as a bidder and a backer within the period,
+// the active or scheduled exclusion could be extended only, the exclusion is kept in the audit
+func (entry *Entry) Exclude(start, end time.Time) error {
+	return entry.ExcludeContext(context.Background(), start, end)
+}
+
+// ExcludeContext excludes the player from tournaments within the period using the context
+func (entry *Entry) ExcludeContext(ctx context.Context, start, end time.Time) error {
+	now := time.Now()
+	if !end.After(start) || !end.After(now) {
+		return ErrInvalidExclusion
+	}
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Exclusion != nil && now.Before(player.Exclusion.End) {
+			if end.Before(player.Exclusion.End) {
+				return ErrExclusionActive
+			}
+			if limits.Excluded(player, now) {
+				// the active exclusion could not be postponed
+				start = player.Exclusion.Start
+			} else if start.After(player.Exclusion.Start) {
+				return ErrExclusionActive
+			}
+		}
+		player.Exclusion = &model.Exclusion{Start: start, End: end}
+		if err := entry.Controller.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		return entry.Controller.NewAuditEntry(player.ID, &model.AuditEntry{
+			Action:  "exclusion",
+			Reason:  "excluded until " + end.Format(time.RFC3339),
+			Actor:   player.ID,
+			Created: now,
+		}, tx)
+	})
+}
+
+// LiftExclusion lifts the exclusion of the player before it ends, it is an admin override allowed
+// to the actors approved by Admin, the actor who lifted the exclusion and the reason are kept in the audit
+func (entry *Entry) LiftExclusion(actor, reason string) error {
+	return entry.LiftExclusionContext(context.Background(), actor, reason)
+}
+
+// LiftExclusionContext lifts the exclusion of the player before it ends using the context
+func (entry *Entry) LiftExclusionContext(ctx context.Context, actor, reason string) error {
+	if Admin == nil || actor == entry.ID() || !Admin(ctx, actor) {
+		return ErrNotAdmin
+	}
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Exclusion == nil || !time.Now().Before(player.Exclusion.End) {
+			return ErrNotExcluded
+		}
+		player.Exclusion = nil
+		if err := entry.Controller.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		return entry.Controller.NewAuditEntry(player.ID, &model.AuditEntry{
+			Action:  "exclusion lifted",
+			Reason:  reason,
+			Actor:   actor,
+			Created: time.Now(),
+		}, tx)
+	})
+}
+
+// Exclusion returns the exclusion of the player which is not ended yet or nil
+func (entry *Entry) Exclusion() (*model.Exclusion, error) {
+	return entry.ExclusionContext(context.Background())
+}
+
+// ExclusionContext returns the exclusion of the player which is not ended yet using the context
+func (entry *Entry) ExclusionContext(ctx context.Context) (*model.Exclusion, error) {
+	var exclusion *model.Exclusion
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayer(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Exclusion != nil && time.Now().Before(player.Exclusion.End) {
+			exclusion = player.Exclusion
+		}
+		return nil
+	})
+	return exclusion, err
+}
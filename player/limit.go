@@ -0,0 +1,59 @@
+package player
+
+import (
+	"context"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/limits"
+	"github.com/takama/backer/model"
+)
+
+// SetLimits sets own spending and backing limits of the player, zero limits are taken from limits.Defaults,
+// stricter limits take effect at once and looser limits after limits.CoolingOff,
+// the actor who set the limits is kept in the audit
+func (entry *Entry) SetLimits(actor string, own model.Limits) error {
+	return entry.SetLimitsContext(context.Background(), actor, own)
+}
+
+// SetLimitsContext sets own spending and backing limits of the player using the context
+func (entry *Entry) SetLimitsContext(ctx context.Context, actor string, own model.Limits) error {
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Status == model.Closed {
+			return ErrAccountClosed
+		}
+		limits.Set(player, own, time.Now())
+		if err := entry.Controller.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		return entry.Controller.NewAuditEntry(player.ID, &model.AuditEntry{
+			Action:  "limits",
+			Actor:   actor,
+			Created: time.Now(),
+		}, tx)
+	})
+}
+
+// Limits returns effective limits of the player and points used within the current periods
+func (entry *Entry) Limits() (model.Limits, model.Limits, error) {
+	return entry.LimitsContext(context.Background())
+}
+
+// LimitsContext returns effective limits of the player and used points using the context
+func (entry *Entry) LimitsContext(ctx context.Context) (model.Limits, model.Limits, error) {
+	var effective, used model.Limits
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayer(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		effective = limits.Of(player, time.Now())
+		used, err = limits.Used(entry.Controller, tx, player.ID, time.Now())
+		return err
+	})
+	return effective, used, err
+}
@@ -1,21 +1,45 @@
 package player
 
 import (
+	"context"
 	"errors"
-	"math"
+	"fmt"
 	"sync"
+	"time"
 
 	"github.com/takama/backer"
 	"github.com/takama/backer/datastore"
-	"github.com/takama/backer/helper"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/limits"
 	"github.com/takama/backer/model"
+	"github.com/takama/backer/stats"
 )
 
 var (
 	// ErrInsufficientPoints appears if player has not enough points
-	ErrInsufficientPoints = errors.New("Insufficient points")
+	ErrInsufficientPoints = ledger.ErrInsufficientPoints
+	// ErrAccountSuspended appears if points are taken from the suspended account
+	ErrAccountSuspended = ledger.ErrAccountSuspended
+	// ErrAccountClosed appears if the closed account is changed
+	ErrAccountClosed = ledger.ErrAccountClosed
+	// ErrNonZeroBalance appears if the player could not be removed or closed while it has points
+	ErrNonZeroBalance = errors.New("Could not remove or close the player with non-zero balance")
+	// ErrOpenStakes appears if the player could not be removed or closed while it has stakes in open tournaments
+	ErrOpenStakes = errors.New("Could not remove or close the player with stakes in open tournaments")
+	// ErrOpenHolds appears if the player could not be removed or closed while it has open holds
+	ErrOpenHolds = errors.New("Could not remove or close the player with open holds")
 )
 
+// Funds contains the player balance split into held and available points,
+// available points include the credit line and outstanding credit is the negative part of the balance
+type Funds struct {
+	Balance     backer.Points
+	Held        backer.Points
+	Available   backer.Points
+	Credit      backer.Points
+	Outstanding backer.Points
+}
+
 // Entry implements Player interface
 type Entry struct {
 	datastore.Controller `json:"-"`
@@ -25,26 +49,25 @@ type Entry struct {
 
 // New returns new Entry which implement Player interface
 func New(id string, ctrl datastore.Controller) (*Entry, error) {
-	tx, err := ctrl.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
+	return NewContext(context.Background(), id, ctrl)
+}
 
+// NewContext returns new Entry which implement Player interface using the context
+func NewContext(ctx context.Context, id string, ctrl datastore.Controller) (*Entry, error) {
 	entry := &Entry{Controller: ctrl}
 
-	player, err := ctrl.FindPlayer(id, tx)
-	if err != nil {
-		err = ctrl.NewPlayer(id, tx)
+	err := datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		player, err := ctrl.FindPlayer(id, tx)
 		if err != nil {
-			tx.Rollback()
-			return nil, err
+			err = ctrl.NewPlayer(id, tx)
+			if err != nil {
+				return err
+			}
+			player = &model.Player{ID: id}
 		}
-		player = &model.Player{ID: id}
-	}
-	entry.Player = *player
-
-	err = tx.Commit()
+		entry.Player = *player
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -54,84 +77,319 @@ func New(id string, ctrl datastore.Controller) (*Entry, error) {
 
 // Find returns Entry with existing Player
 func Find(id string, ctrl datastore.Controller) (*Entry, error) {
-	tx, err := ctrl.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
+	return FindContext(context.Background(), id, ctrl)
+}
 
+// FindContext returns Entry with existing Player using the context
+func FindContext(ctx context.Context, id string, ctrl datastore.Controller) (*Entry, error) {
 	entry := &Entry{Controller: ctrl}
 
-	player, err := ctrl.FindPlayer(id, tx)
+	err := datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		player, err := ctrl.FindPlayer(id, tx)
+		if err != nil {
+			return err
+		}
+		entry.Player = *player
+		return nil
+	})
 	if err != nil {
-		tx.Rollback()
 		return nil, err
 	}
-	entry.Player = *player
 
-	err = tx.Commit()
+	return entry, nil
+}
+
+// Delete removes existing player,
+// the player should have zero balances in all wallets, no open holds and no stakes in open tournaments
+func Delete(id string, ctrl datastore.Controller) error {
+	return DeleteContext(context.Background(), id, ctrl)
+}
+
+// DeleteContext removes existing player using the context
+func DeleteContext(ctx context.Context, id string, ctrl datastore.Controller) error {
+	return remove(ctx, id, ctrl, ctrl.DeletePlayer)
+}
+
+// Archive hides existing player until it is restored,
+// the player should have zero balances in all wallets, no open holds and no stakes in open tournaments
+func Archive(id string, ctrl datastore.Controller) error {
+	return ArchiveContext(context.Background(), id, ctrl)
+}
+
+// ArchiveContext hides existing player using the context
+func ArchiveContext(ctx context.Context, id string, ctrl datastore.Controller) error {
+	return remove(ctx, id, ctrl, ctrl.ArchivePlayer)
+}
+
+// Restore returns archived player back
+func Restore(id string, ctrl datastore.Controller) error {
+	return RestoreContext(context.Background(), id, ctrl)
+}
+
+// RestoreContext returns archived player back using the context
+func RestoreContext(ctx context.Context, id string, ctrl datastore.Controller) error {
+	return datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		return ctrl.RestorePlayer(id, tx)
+	})
+}
+
+func remove(ctx context.Context, id string, ctrl datastore.Controller,
+	action func(ID string, tx datastore.Transact) error) error {
+	return datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		player, err := ctrl.FindPlayerForUpdate(id, tx)
+		if err != nil {
+			return err
+		}
+
+		if err := settled(ctrl, tx, player); err != nil {
+			return err
+		}
+
+		return action(id, tx)
+	})
+}
+
+// settled returns an error if the player has open holds, points or stakes in open tournaments
+func settled(ctrl datastore.Controller, tx datastore.Transact, player *model.Player) error {
+	if ledger.Held(player, time.Now()) != 0 {
+		return ErrOpenHolds
+	}
+
+	if player.Balance != 0 {
+		return ErrNonZeroBalance
+	}
+	for _, balance := range player.Wallets {
+		if balance != 0 {
+			return ErrNonZeroBalance
+		}
+	}
+
+	tournaments, _, err := ctrl.ListTournaments(datastore.TournamentQuery{
+		State:       datastore.Open,
+		Participant: player.ID,
+		Page:        datastore.Page{Limit: 1},
+	}, tx)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return entry, nil
+	if len(tournaments) > 0 {
+		return ErrOpenStakes
+	}
+
+	return nil
 }
 
 // Fund funds (add to balance) player with amount
 func (entry *Entry) Fund(amount backer.Points) error {
-	tx, err := entry.Controller.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return err
+	return entry.FundContext(context.Background(), amount)
+}
+
+// FundContext funds (add to balance) player with amount using the context,
+// the player is funded once per idempotency key of the context, see datastore.WithIdempotencyKey
+func (entry *Entry) FundContext(ctx context.Context, amount backer.Points) error {
+	return entry.FundInContext(ctx, "", amount)
+}
+
+// FundIn funds the player wallet in the currency with amount, the empty currency means the default points
+func (entry *Entry) FundIn(currency string, amount backer.Points) error {
+	return entry.FundInContext(context.Background(), currency, amount)
+}
+
+// FundInContext funds the player wallet in the currency with amount using the context
+func (entry *Entry) FundInContext(ctx context.Context, currency string, amount backer.Points) error {
+	operation := ledger.New(ledger.Fund, ledger.House, ledger.Player(entry.ID()), amount)
+	operation.Currency = currency
+	return entry.post(ctx, request("fund", entry.ID(), currency, amount), operation, nil)
+}
+
+// Take takes points from player account
+func (entry *Entry) Take(amount backer.Points) error {
+	return entry.TakeContext(context.Background(), amount)
+}
+
+// TakeContext takes points from player account using the context,
+// the points are taken once per idempotency key of the context, see datastore.WithIdempotencyKey
+func (entry *Entry) TakeContext(ctx context.Context, amount backer.Points) error {
+	return entry.TakeInContext(ctx, "", amount)
+}
+
+// TakeIn takes points from the player wallet in the currency, the empty currency means the default points
+func (entry *Entry) TakeIn(currency string, amount backer.Points) error {
+	return entry.TakeInContext(context.Background(), currency, amount)
+}
+
+// TakeInContext takes points from the player wallet in the currency using the context,
+// the default points are taken within the spending limits of the player, see limits.Check
+func (entry *Entry) TakeInContext(ctx context.Context, currency string, amount backer.Points) error {
+	operation := ledger.New(ledger.Take, ledger.Player(entry.ID()), ledger.House, amount)
+	operation.Currency = currency
+	return entry.post(ctx, request("take", entry.ID(), currency, amount), operation,
+		func(tx datastore.Transact) error {
+			if currency != "" {
+				return nil
+			}
+			player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+			if err != nil {
+				return err
+			}
+			return limits.Check(entry.Controller, tx, player, limits.Spend, operation.Postings[1].Amount, time.Now())
+		})
+}
+
+// request returns the idempotency request of the player operation in the currency
+func request(kind, ID, currency string, amount backer.Points) string {
+	if currency == "" {
+		return fmt.Sprintf("%s:%s:%v", kind, ID, amount)
 	}
+	return fmt.Sprintf("%s:%s:%s:%v", kind, ID, currency, amount)
+}
 
-	balance, err := ManagePoints(entry.Controller, tx, entry.Player.ID, amount)
-	if err != nil {
-		tx.Rollback()
+// post records the ledger operation once per idempotency key of the context
+// and refreshes the player balance, the operation is rejected if the check fails
+func (entry *Entry) post(ctx context.Context, request string, operation *model.Operation,
+	check func(tx datastore.Transact) error) error {
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		_, _, err := datastore.Once(ctx, entry.Controller, tx, request, func() (string, error) {
+			if check != nil {
+				if err := check(tx); err != nil {
+					return "", err
+				}
+			}
+			err := ledger.Post(entry.Controller, tx, operation)
+			return operation.ID, err
+		})
+		return err
+	})
+}
+
+// Hold earmarks amount of available points until the hold expires and returns the hold ID,
+// held points could not be spent until the hold is captured, released or expired
+func (entry *Entry) Hold(amount backer.Points, expires time.Time) (string, error) {
+	return entry.HoldContext(context.Background(), amount, expires)
+}
+
+// HoldContext earmarks amount of available points until the hold expires using the context
+func (entry *Entry) HoldContext(ctx context.Context, amount backer.Points, expires time.Time) (string, error) {
+	var hold *model.Hold
+	err := entry.update(ctx, func(tx datastore.Transact) (err error) {
+		hold, err = ledger.PlaceHold(entry.Controller, tx, entry.ID(), amount, expires)
 		return err
+	})
+	if err != nil {
+		return "", err
 	}
 
-	err = tx.Commit()
+	return hold.ID, nil
+}
+
+// Capture takes held points of the hold from player account
+func (entry *Entry) Capture(hold string) error {
+	return entry.CaptureContext(context.Background(), hold)
+}
+
+// CaptureContext takes held points of the hold from player account using the context,
+// the points are taken within the spending limits of the player as Take does, see limits.Check
+func (entry *Entry) CaptureContext(ctx context.Context, hold string) error {
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		now := time.Now()
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		for _, held := range player.Holds {
+			if held.ID == hold && now.Before(held.Expires) {
+				if err := limits.Check(entry.Controller, tx, player, limits.Spend, held.Amount, now); err != nil {
+					return err
+				}
+			}
+		}
+		_, err = ledger.CaptureHold(entry.Controller, tx, entry.ID(), hold)
+		return err
+	})
+}
+
+// Release makes held points of the hold available again
+func (entry *Entry) Release(hold string) error {
+	return entry.ReleaseContext(context.Background(), hold)
+}
+
+// ReleaseContext makes held points of the hold available again using the context
+func (entry *Entry) ReleaseContext(ctx context.Context, hold string) error {
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		return ledger.ReleaseHold(entry.Controller, tx, entry.ID(), hold)
+	})
+}
+
+// update runs fn within the unit of work and refreshes the player balance and holds
+func (entry *Entry) update(ctx context.Context, fn func(tx datastore.Transact) error) error {
+	var player *model.Player
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		if err = fn(tx); err != nil {
+			return err
+		}
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
-	entry.Player.Balance = balance
+	entry.Player.Balance = player.Balance
+	entry.Player.Holds = player.Holds
+	entry.Player.Wallets = player.Wallets
 
 	return nil
 }
 
-// Take takes points from player account
-func (entry *Entry) Take(amount backer.Points) error {
-	tx, err := entry.Controller.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+// Funds gets current points split into held and available points
+func (entry *Entry) Funds() (Funds, error) {
+	return entry.FundsContext(context.Background())
+}
 
-	balance, err := ManagePoints(entry.Controller, tx, entry.Player.ID, -amount)
-	if err != nil {
-		tx.Rollback()
+// FundsContext gets current points split into held and available points using the context
+func (entry *Entry) FundsContext(ctx context.Context) (Funds, error) {
+	var player *model.Player
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
 		return err
+	})
+	if err != nil {
+		return Funds{}, err
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		return err
+	now := time.Now()
+	funds := Funds{
+		Balance:     player.Balance,
+		Held:        ledger.Held(player, now),
+		Available:   ledger.Available(player, now),
+		Credit:      player.CreditLimit,
+		Outstanding: ledger.Outstanding(player),
 	}
 
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
-	entry.Player.Balance = balance
+	entry.Player.Balance = player.Balance
+	entry.Player.Holds = player.Holds
+	entry.Player.Wallets = player.Wallets
 
-	return nil
+	return funds, nil
 }
 
-// Balance gets current points
+// Balance gets current points including held points
 func (entry *Entry) Balance() (backer.Points, error) {
-	player, err := entry.Controller.FindPlayer(entry.Player.ID, nil)
+	return entry.BalanceContext(context.Background())
+}
+
+// BalanceContext gets current points using the context
+func (entry *Entry) BalanceContext(ctx context.Context) (backer.Points, error) {
+	var player *model.Player
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
@@ -143,6 +401,102 @@ func (entry *Entry) Balance() (backer.Points, error) {
 	return player.Balance, nil
 }
 
+// Wallet gets current points of the player in the currency, the empty currency means the default points
+func (entry *Entry) Wallet(currency string) (backer.Points, error) {
+	return entry.WalletContext(context.Background(), currency)
+}
+
+// WalletContext gets current points of the player in the currency using the context
+func (entry *Entry) WalletContext(ctx context.Context, currency string) (backer.Points, error) {
+	if currency == "" {
+		return entry.BalanceContext(ctx)
+	}
+	var player *model.Player
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.Player.Wallets = player.Wallets
+
+	return player.Wallets[currency], nil
+}
+
+// Stats returns performance statistics of the player as a bidder and as a backer
+func (entry *Entry) Stats() (*stats.Report, error) {
+	return entry.StatsContext(context.Background())
+}
+
+// StatsContext returns performance statistics of the player using the context
+func (entry *Entry) StatsContext(ctx context.Context) (*stats.Report, error) {
+	var report *stats.Report
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) error {
+		if _, err := entry.Controller.FindPlayer(entry.ID(), tx); err != nil {
+			return err
+		}
+		var err error
+		report, err = stats.Of(entry.Controller, tx, entry.ID())
+		return err
+	})
+	return report, err
+}
+
+// Expirations returns funded points of the player which expire not later than until
+func (entry *Entry) Expirations(until time.Time) ([]model.Lot, error) {
+	return entry.ExpirationsContext(context.Background(), until)
+}
+
+// ExpirationsContext returns funded points of the player which expire not later than until using the context
+func (entry *Entry) ExpirationsContext(ctx context.Context, until time.Time) ([]model.Lot, error) {
+	var player *model.Player
+	var lots []model.Lot
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		lots, err = ledger.Expiring(entry.Controller, tx, entry.ID(), time.Now(), until)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.Player.Balance = player.Balance
+	entry.Player.Wallets = player.Wallets
+
+	return lots, nil
+}
+
+// Statement returns a page of the player movements within the date range and the next page cursor,
+// zero dates are not applied, the date range includes from and excludes to
+func (entry *Entry) Statement(from, to time.Time, page datastore.Page) ([]ledger.Movement, string, error) {
+	return entry.StatementContext(context.Background(), from, to, page)
+}
+
+// StatementContext returns a page of the player movements within the date range using the context
+func (entry *Entry) StatementContext(ctx context.Context,
+	from, to time.Time, page datastore.Page) ([]ledger.Movement, string, error) {
+	var movements []ledger.Movement
+	var next string
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		movements, next, err = ledger.Statement(entry.Controller, tx, entry.ID(), from, to, page)
+		return err
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return movements, next, nil
+}
+
 // ID returns player ID
 func (entry *Entry) ID() string {
 	entry.mutex.RLock()
@@ -150,23 +504,22 @@ func (entry *Entry) ID() string {
 	return entry.Player.ID
 }
 
-// ManagePoints manage player balance with amount using external transaction
+// ManagePoints manage player balance with amount using external transaction,
+// positive amount is posted to the ledger as fund and negative one as take,
+// the player is locked until the end of the transaction and its new balance is returned
 func ManagePoints(ctrl datastore.Controller, tx datastore.Transact,
 	id string, amount backer.Points) (backer.Points, error) {
-	player, err := ctrl.FindPlayer(id, tx)
-	if err != nil {
-		return 0, err
+	operation := ledger.New(ledger.Fund, ledger.House, ledger.Player(id), amount)
+	if amount < 0 {
+		operation = ledger.New(ledger.Take, ledger.Player(id), ledger.House, -amount)
 	}
-	if amount < 0 && player.Balance < backer.Points(math.Abs(float64(amount))) {
-		return 0, ErrInsufficientPoints
-	}
-
-	player.Balance = backer.Points(
-		helper.RoundPrice(float32(player.Balance) + helper.TruncatePrice(float32(amount))))
-	err = ctrl.SavePlayer(player, tx)
-	if err != nil {
+	if err := ledger.Post(ctrl, tx, operation); err != nil {
 		return 0, err
 	}
-
-	return player.Balance, nil
+	for _, posting := range operation.Postings {
+		if posting.Account == ledger.Player(id) {
+			return posting.Balance, nil
+		}
+	}
+	return 0, nil
 }
@@ -1,10 +1,16 @@
 package player
 
 import (
+	"context"
 	"errors"
 	"testing"
+	"time"
 
+	"github.com/takama/backer"
 	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/limits"
+	"github.com/takama/backer/model"
 )
 
 var (
@@ -20,7 +26,7 @@ var (
 
 func test(t *testing.T, expected bool, messages ...interface{}) {
 	if !expected {
-		t.Error(messages)
+		t.Error(messages...)
 	}
 }
 
@@ -166,3 +172,580 @@ func TestPlayerID(t *testing.T) {
 	id := entry.ID()
 	test(t, id == entry.Player.ID, "Expected the player id,", entry.Player.ID, " got", id)
 }
+
+func TestManagePoints(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	_, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	var balance backer.Points
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) (err error) {
+		balance, err = ManagePoints(store, tx, "p1", 100)
+		return err
+	})
+	test(t, err == nil && balance == 100, "Expected 100 points of the player, got", balance, err)
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) (err error) {
+		balance, err = ManagePoints(store, tx, "p1", -40)
+		return err
+	})
+	test(t, err == nil && balance == 60, "Expected 60 points of the player, got", balance, err)
+	_, err = ManagePoints(store, nil, "p1", -100)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil && report.Reconciled(), "Expected reconciled ledger, got", report, err)
+}
+
+// racyStub changes the player concurrently right after it was found
+type racyStub struct {
+	*datastore.Stub
+	races int
+}
+
+func (stub *racyStub) FindPlayerForUpdate(ID string, tx datastore.Transact) (*model.Player, error) {
+	player, err := stub.Stub.FindPlayerForUpdate(ID, tx)
+	if err == nil && stub.races > 0 {
+		stub.races--
+		concurrent := *player
+		concurrent.Balance += 100
+		stub.Stub.SavePlayer(&concurrent, tx)
+	}
+	return player, err
+}
+
+func TestPlayerConflict(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	stale, err := store.FindPlayer("p1", nil)
+	test(t, err == nil, "Expected find the player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	stale.Balance = 1000
+	err = store.SavePlayer(stale, nil)
+	test(t, datastore.IsConflict(err), "Expected conflict error, got", err)
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 300, "Expected 300 points for the player, got", balance)
+
+	racy := &racyStub{Stub: store, races: 2}
+	entry, err = Find("p1", racy)
+	test(t, err == nil, "Expected find existing player, got", err)
+	err = entry.Take(200)
+	test(t, err == nil, "Expected take 200 from the player, got", err)
+	balance, err = entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+
+	racy.races = datastore.MaxRetries + 1
+	err = entry.Fund(50)
+	test(t, datastore.IsConflict(err), "Expected conflict error, got", err)
+	balance, err = entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+}
+
+func TestPlayerDelete(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(100)
+	test(t, err == nil, "Expected fund 100 to the player, got", err)
+	err = Delete("p1", store)
+	test(t, err == ErrNonZeroBalance, "Expected", ErrNonZeroBalance, "got", err)
+	err = Archive("p1", store)
+	test(t, err == ErrNonZeroBalance, "Expected", ErrNonZeroBalance, "got", err)
+	err = entry.Take(100)
+	test(t, err == nil, "Expected take 100 from the player, got", err)
+
+	err = entry.FundIn("tickets", 50)
+	test(t, err == nil, "Expected fund 50 tickets to the player, got", err)
+	err = Delete("p1", store)
+	test(t, err == ErrNonZeroBalance, "Expected", ErrNonZeroBalance, "got", err)
+	err = entry.TakeIn("tickets", 50)
+	test(t, err == nil, "Expected take 50 tickets from the player, got", err)
+	err = entry.Fund(50)
+	test(t, err == nil, "Expected fund 50 to the player, got", err)
+	hold, err := entry.Hold(50, time.Now().Add(time.Hour))
+	test(t, err == nil, "Expected hold 50 points of the player, got", err)
+	err = Delete("p1", store)
+	test(t, err == ErrOpenHolds, "Expected", ErrOpenHolds, "got", err)
+	err = entry.Release(hold)
+	test(t, err == nil, "Expected release of the hold, got", err)
+	err = entry.Take(50)
+	test(t, err == nil, "Expected take 50 from the player, got", err)
+
+	store.NewTournament(1, nil)
+	tournament, _ := store.FindTournament(1, nil)
+	tournament.Bidders = append(tournament.Bidders, model.Bidder{ID: "p2", Backers: []string{"p1"}})
+	store.SaveTournament(tournament, nil)
+	err = Delete("p1", store)
+	test(t, err == ErrOpenStakes, "Expected", ErrOpenStakes, "got", err)
+	tournament.IsFinished = true
+	store.SaveTournament(tournament, nil)
+
+	err = Archive("p1", store)
+	test(t, err == nil, "Expected archive the player, got", err)
+	_, err = Find("p1", store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+	players, _, err := store.ListPlayers(datastore.PlayerQuery{Archived: true}, nil)
+	test(t, err == nil, "Expected list of players, got", err)
+	test(t, len(players) == 1 && players[0].ID == "p1", "Expected archived p1 player, got", players)
+	err = Restore("p1", store)
+	test(t, err == nil, "Expected restore the player, got", err)
+	err = Restore("p1", store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+	_, err = Find("p1", store)
+	test(t, err == nil, "Expected find restored player, got", err)
+
+	store.ErrTx = append(store.ErrTx, ErrFalseTransaction)
+	err = Delete("p1", store)
+	test(t, err == ErrFalseTransaction, "Expected", ErrFalseTransaction, "got", err)
+	err = Delete("p1", store)
+	test(t, err == nil, "Expected delete the player, got", err)
+	_, err = Find("p1", store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+	err = Delete("p1", store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+}
+
+func TestPlayerContext(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	entry, err := NewContext(ctx, "p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.FundContext(ctx, 300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	err = entry.TakeContext(ctx, 100)
+	test(t, err == nil, "Expected take 100 from the player, got", err)
+	balance, err := entry.BalanceContext(ctx)
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 200, "Expected 200 points for the player, got", balance)
+
+	cancel()
+	err = entry.FundContext(ctx, 300)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	_, err = entry.BalanceContext(ctx)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	_, err = FindContext(ctx, "p1", store)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	balance, err = entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 200, "Expected 200 points for the player, got", balance)
+}
+
+func TestPlayerStatement(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	err = entry.Take(100)
+	test(t, err == nil, "Expected take 100 from the player, got", err)
+	movements, next, err := entry.Statement(time.Time{}, time.Time{}, datastore.Page{})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, next == "", "Expected no more pages, got", next)
+	test(t, len(movements) == 2, "Expected 2 movements, got", len(movements))
+	test(t, movements[0].Amount == 300 && movements[0].Balance == 300,
+		"Expected fund 300 in the statement, got", movements[0])
+	test(t, movements[1].Amount == -100 && movements[1].Balance == 200,
+		"Expected take 100 in the statement, got", movements[1])
+	movements, _, err = entry.Statement(time.Now(), time.Time{}, datastore.Page{})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, len(movements) == 0, "Expected no movements, got", len(movements))
+	store.ErrFind = append(store.ErrFind, ErrFindPlayer)
+	_, _, err = entry.Statement(time.Time{}, time.Time{}, datastore.Page{})
+	test(t, err == ErrFindPlayer, "Expected", ErrFindPlayer, "got", err)
+}
+
+func TestPlayerIdempotency(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	ctx := datastore.WithIdempotencyKey(context.Background(), "fund-1")
+	for idx := 0; idx < 3; idx++ {
+		err = entry.FundContext(ctx, 300)
+		test(t, err == nil, "Expected fund 300 to the player, got", err)
+	}
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 300, "Expected 300 points for the player, got", balance)
+	err = entry.TakeContext(ctx, 300)
+	test(t, err == datastore.ErrIdempotencyKeyReused, "Expected", datastore.ErrIdempotencyKeyReused, "got", err)
+
+	ctx = datastore.WithIdempotencyKey(context.Background(), "take-1")
+	err = entry.TakeContext(ctx, 400)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = entry.Fund(100)
+	test(t, err == nil, "Expected fund 100 to the player, got", err)
+	for idx := 0; idx < 2; idx++ {
+		err = entry.TakeContext(ctx, 400)
+		test(t, err == nil, "Expected take 400 from the player, got", err)
+	}
+	balance, err = entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 0, "Expected 0 points for the player, got", balance)
+}
+
+func TestPlayerHolds(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	first, err := entry.Hold(200, time.Now().Add(time.Hour))
+	test(t, err == nil, "Expected hold 200 points, got", err)
+	second, err := entry.Hold(50, time.Now().Add(time.Hour))
+	test(t, err == nil, "Expected hold 50 points, got", err)
+	funds, err := entry.Funds()
+	test(t, err == nil, "Expected funds of the player, got", err)
+	test(t, funds == Funds{Balance: 300, Held: 250, Available: 50}, "Expected 250 held points, got", funds)
+	err = entry.Take(100)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+
+	err = entry.Release(second)
+	test(t, err == nil, "Expected release of the hold, got", err)
+	err = entry.Capture(first)
+	test(t, err == nil, "Expected capture of the hold, got", err)
+	err = entry.Capture(first)
+	test(t, err == ledger.ErrHoldNotFound, "Expected", ledger.ErrHoldNotFound, "got", err)
+	funds, err = entry.Funds()
+	test(t, err == nil, "Expected funds of the player, got", err)
+	test(t, funds == Funds{Balance: 100, Available: 100}, "Expected 100 available points, got", funds)
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+}
+
+func TestPlayerTransfer(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	bounds := Limits
+	defer func() { Limits = bounds }()
+	Limits = TransferLimits{Min: 1, Max: 200}
+	p1, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = p1.SetLimits("p1", model.Limits{Spend: model.Periods{Daily: 250}})
+	test(t, err == nil, "Expected setting limits of the player, got", err)
+	p2, err := New("p2", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = p1.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+
+	err = Transfer("p1", "p1", 10, "", store)
+	test(t, err == ErrInvalidTransfer, "Expected", ErrInvalidTransfer, "got", err)
+	err = Transfer("p1", "p2", -10, "", store)
+	test(t, err == ErrInvalidTransfer, "Expected", ErrInvalidTransfer, "got", err)
+	err = Transfer("p1", "p2", 0.5, "", store)
+	test(t, err == ErrTransferLimit, "Expected", ErrTransferLimit, "got", err)
+	err = Transfer("p1", "p2", 201, "", store)
+	test(t, err == ErrTransferLimit, "Expected", ErrTransferLimit, "got", err)
+	err = Transfer("p1", "p3", 10, "", store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+
+	err = Transfer("p1", "p2", 200, "lunch", store)
+	test(t, err == nil, "Expected transfer 200 points, got", err)
+	err = Transfer("p1", "p2", 60, "", store)
+	test(t, limits.IsLimit(err), "Expected daily limit error, got", err)
+	err = Transfer("p2", "p1", 150, "", store)
+	test(t, err == nil, "Expected transfer 150 points back, got", err)
+	err = Transfer("p1", "p2", 50, "", store)
+	test(t, err == nil, "Expected transfer 50 points up to the daily limit, got", err)
+
+	movements, _, err := p1.Statement(time.Time{}, time.Time{}, datastore.Page{})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, len(movements) == 4, "Expected 4 movements, got", len(movements))
+	test(t, movements[1].Kind == ledger.Transfer && movements[1].Amount == -200 && movements[1].Memo == "lunch" &&
+		len(movements[1].Counterparties) == 1 && movements[1].Counterparties[0] == ledger.Player("p2"),
+		"Expected transfer to p2 in the statement, got", movements[1])
+	movements, _, err = p2.Statement(time.Time{}, time.Time{}, datastore.Page{})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, len(movements) == 3, "Expected 3 movements, got", len(movements))
+	test(t, movements[0].Amount == 200 && movements[0].Memo == "lunch" &&
+		movements[0].Counterparties[0] == ledger.Player("p1"),
+		"Expected transfer from p1 in the statement, got", movements[0])
+
+	balance, err := p1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 200, "Expected 200 points for the player, got", balance)
+	balance, err = p2.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+
+	err = Transfer("p2", "p1", 150, "", store)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	ctx := datastore.WithIdempotencyKey(context.Background(), "transfer-1")
+	for idx := 0; idx < 2; idx++ {
+		err = TransferContext(ctx, "p2", "p1", 100, "", store)
+		test(t, err == nil, "Expected transfer 100 points, got", err)
+	}
+	err = TransferContext(ctx, "p2", "p1", 100, "refund", store)
+	test(t, err == datastore.ErrIdempotencyKeyReused, "Expected", datastore.ErrIdempotencyKeyReused, "got", err)
+	balance, err = p2.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 0, "Expected 0 points for the player, got", balance)
+}
+
+func TestPlayerExpirations(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	defer func() { ledger.FundExpiry = 0 }()
+	ledger.FundExpiry = 24 * time.Hour
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	err = entry.Take(100)
+	test(t, err == nil, "Expected take 100 from the player, got", err)
+	lots, err := entry.Expirations(time.Now().Add(48 * time.Hour))
+	test(t, err == nil, "Expected expirations of the player, got", err)
+	test(t, len(lots) == 1 && lots[0].Amount == 200, "Expected 200 points to expire, got", lots)
+	lots, err = entry.Expirations(time.Now())
+	test(t, err == nil, "Expected expirations of the player, got", err)
+	test(t, len(lots) == 0, "Expected no points to expire, got", lots)
+	store.ErrFind = append(store.ErrFind, ErrFindPlayer)
+	_, err = entry.Expirations(time.Now())
+	test(t, err == ErrFindPlayer, "Expected", ErrFindPlayer, "got", err)
+}
+
+func TestPlayerWallets(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(100)
+	test(t, err == nil, "Expected fund 100 to the player, got", err)
+	err = entry.FundIn("loyalty", 50)
+	test(t, err == nil, "Expected fund 50 loyalty points to the player, got", err)
+	err = entry.TakeIn("loyalty", 60)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = entry.TakeIn("bonus", 10)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = entry.TakeIn("loyalty", 20)
+	test(t, err == nil, "Expected take 20 loyalty points from the player, got", err)
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+	loyalty, err := entry.Wallet("loyalty")
+	test(t, err == nil, "Expected check wallet of the player, got", err)
+	test(t, loyalty == 30, "Expected 30 loyalty points for the player, got", loyalty)
+	balance, err = entry.Wallet("")
+	test(t, err == nil, "Expected check wallet of the player, got", err)
+	test(t, balance == 100, "Expected 100 points for the player, got", balance)
+
+	movements, _, err := entry.Statement(time.Time{}, time.Time{}, datastore.Page{})
+	test(t, err == nil, "Expected statement of the player, got", err)
+	test(t, len(movements) == 3, "Expected 3 movements, got", len(movements))
+	test(t, movements[2].Currency == "loyalty" && movements[2].Amount == -20 && movements[2].Balance == 30,
+		"Expected take 20 loyalty points in the statement, got", movements[2])
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation of the ledger, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+
+	store.ErrFind = append(store.ErrFind, ErrFindPlayer)
+	_, err = entry.Wallet("loyalty")
+	test(t, err == ErrFindPlayer, "Expected", ErrFindPlayer, "got", err)
+}
+
+func TestPlayerStatus(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	status, _, err := entry.Status()
+	test(t, err == nil, "Expected status of the player, got", err)
+	test(t, status == model.Active, "Expected active player, got", status)
+
+	err = entry.Suspend("admin", "investigation", false)
+	test(t, err == nil, "Expected suspension of the player, got", err)
+	status, reason, err := entry.Status()
+	test(t, err == nil, "Expected status of the player, got", err)
+	test(t, status == model.Suspended && reason == "investigation", "Expected suspended player, got", status, reason)
+	err = entry.Take(100)
+	test(t, err == ErrAccountSuspended, "Expected", ErrAccountSuspended, "got", err)
+	_, err = entry.Hold(100, time.Now().Add(time.Hour))
+	test(t, err == ErrAccountSuspended, "Expected", ErrAccountSuspended, "got", err)
+	err = entry.Fund(100)
+	test(t, err == nil, "Expected fund 100 to the suspended player, got", err)
+	err = entry.Suspend("admin", "fraud", true)
+	test(t, err == nil, "Expected suspension of the player, got", err)
+	err = entry.Fund(100)
+	test(t, err == ErrAccountSuspended, "Expected", ErrAccountSuspended, "got", err)
+
+	err = entry.Activate("admin", "cleared")
+	test(t, err == nil, "Expected activation of the player, got", err)
+	err = entry.Take(100)
+	test(t, err == nil, "Expected take 100 from the player, got", err)
+	err = entry.Close("admin", "requested")
+	test(t, err == ErrNonZeroBalance, "Expected", ErrNonZeroBalance, "got", err)
+	err = entry.Take(300)
+	test(t, err == nil, "Expected take 300 from the player, got", err)
+	err = entry.Close("admin", "requested")
+	test(t, err == nil, "Expected closing of the player, got", err)
+	err = entry.Fund(100)
+	test(t, err == ErrAccountClosed, "Expected", ErrAccountClosed, "got", err)
+	err = entry.Activate("admin", "mistake")
+	test(t, err == ErrAccountClosed, "Expected", ErrAccountClosed, "got", err)
+
+	audit, err := entry.Audit()
+	test(t, err == nil, "Expected audit of the player, got", err)
+	test(t, len(audit) == 4, "Expected 4 audit entries, got", len(audit))
+	test(t, audit[0].Status == model.Suspended && audit[0].Actor == "admin" && audit[0].Reason == "investigation",
+		"Expected suspension in the audit, got", audit[0])
+	test(t, audit[3].Status == model.Closed && audit[3].Reason == "requested", "Expected closing in the audit, got", audit[3])
+	balance, err := entry.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 0, "Expected 0 points for the player, got", balance)
+}
+
+func TestPlayerLimits(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Fund(300)
+	test(t, err == nil, "Expected fund 300 to the player, got", err)
+	err = entry.SetLimits("p1", model.Limits{Spend: model.Periods{Weekly: 100}})
+	test(t, err == nil, "Expected setting limits of the player, got", err)
+	err = entry.Take(60)
+	test(t, err == nil, "Expected take 60 from the player, got", err)
+	err = entry.Take(60)
+	test(t, limits.IsLimit(err), "Expected limit error, got", err)
+	err = entry.TakeIn("loyalty", 60)
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	hold, err := entry.Hold(60, time.Now().Add(time.Hour))
+	test(t, err == nil, "Expected hold 60 points, got", err)
+	err = entry.Capture(hold)
+	test(t, limits.IsLimit(err), "Expected limit error on capture of the hold, got", err)
+	funds, err := entry.Funds()
+	test(t, err == nil && funds.Balance == 240 && funds.Held == 60,
+		"Expected the hold is not captured, got", funds, err)
+	err = entry.Release(hold)
+	test(t, err == nil, "Expected release of the hold, got", err)
+	_, err = New("p2", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = Transfer("p1", "p2", 50, "", store)
+	test(t, limits.IsLimit(err), "Expected limit error on transfer, got", err)
+	err = Transfer("p1", "p2", 40, "", store)
+	test(t, err == nil, "Expected transfer 40 points, got", err)
+
+	effective, used, err := entry.Limits()
+	test(t, err == nil, "Expected limits of the player, got", err)
+	test(t, effective.Spend.Weekly == 100, "Expected weekly limit of 100 points, got", effective)
+	test(t, used.Spend.Weekly == 100, "Expected 100 points spent within the week, got", used)
+	audit, err := entry.Audit()
+	test(t, err == nil, "Expected audit of the player, got", err)
+	test(t, len(audit) == 1 && audit[0].Action == "limits", "Expected limits change in the audit, got", audit)
+}
+
+func TestPlayerExclusion(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	defer func() { Admin = nil }()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	now := time.Now()
+	err = entry.Exclude(now, now.Add(-time.Hour))
+	test(t, err == ErrInvalidExclusion, "Expected", ErrInvalidExclusion, "got", err)
+	err = entry.LiftExclusion("admin", "mistake")
+	test(t, err == ErrNotAdmin, "Expected", ErrNotAdmin, "got", err)
+	Admin = func(ctx context.Context, actor string) bool { return actor == "admin" || actor == "p1" }
+	err = entry.LiftExclusion("admin", "mistake")
+	test(t, err == ErrNotExcluded, "Expected", ErrNotExcluded, "got", err)
+
+	err = entry.Exclude(now, now.Add(24*time.Hour))
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	err = entry.Exclude(now, now.Add(time.Hour))
+	test(t, err == ErrExclusionActive, "Expected", ErrExclusionActive, "got", err)
+	err = entry.Exclude(now.Add(time.Hour), now.Add(48*time.Hour))
+	test(t, err == nil, "Expected extension of the exclusion, got", err)
+	exclusion, err := entry.Exclusion()
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	test(t, exclusion != nil && exclusion.Start.Equal(now) && exclusion.End.Equal(now.Add(48*time.Hour)),
+		"Expected exclusion for 48 hours, got", exclusion)
+
+	err = entry.LiftExclusion("support", "support request")
+	test(t, err == ErrNotAdmin, "Expected", ErrNotAdmin, "got", err)
+	err = entry.LiftExclusion("p1", "support request")
+	test(t, err == ErrNotAdmin, "Expected the player could not lift own exclusion, got", err)
+	err = entry.LiftExclusion("admin", "support request")
+	test(t, err == nil, "Expected lifting of the exclusion, got", err)
+	exclusion, err = entry.Exclusion()
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	test(t, exclusion == nil, "Expected no exclusion, got", exclusion)
+	audit, err := entry.Audit()
+	test(t, err == nil, "Expected audit of the player, got", err)
+	test(t, len(audit) == 3, "Expected 3 audit entries, got", len(audit))
+	test(t, audit[2].Action == "exclusion lifted" && audit[2].Actor == "admin" && audit[2].Reason == "support request",
+		"Expected lifted exclusion in the audit, got", audit[2])
+
+	err = entry.Exclude(now.Add(time.Hour), now.Add(30*24*time.Hour))
+	test(t, err == nil, "Expected scheduled exclusion of the player, got", err)
+	err = entry.Exclude(now.Add(time.Hour), now.Add(time.Hour+time.Second))
+	test(t, err == ErrExclusionActive, "Expected", ErrExclusionActive, "got", err)
+	err = entry.Exclude(now.Add(2*time.Hour), now.Add(31*24*time.Hour))
+	test(t, err == ErrExclusionActive, "Expected", ErrExclusionActive, "got", err)
+	err = entry.Exclude(now.Add(30*time.Minute), now.Add(31*24*time.Hour))
+	test(t, err == nil, "Expected extension of the scheduled exclusion, got", err)
+	exclusion, err = entry.Exclusion()
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	test(t, exclusion != nil && exclusion.Start.Equal(now.Add(30*time.Minute)) &&
+		exclusion.End.Equal(now.Add(31*24*time.Hour)), "Expected extended scheduled exclusion, got", exclusion)
+}
+
+func TestPlayerCredit(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	entry, err := New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.SetCreditLimit("admin", -100)
+	test(t, err == ErrInvalidCreditLimit, "Expected", ErrInvalidCreditLimit, "got", err)
+	err = entry.SetCreditLimit("admin", 100)
+	test(t, err == nil, "Expected setting credit limit of the player, got", err)
+	_, err = New("p2", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = entry.Take(100)
+	test(t, err == ErrInsufficientPoints, "Expected credit is not taken, got", err)
+	err = Transfer("p1", "p2", 100, "", store)
+	test(t, err == ErrInsufficientPoints, "Expected credit is not transferred, got", err)
+	_, err = entry.Hold(100, time.Now().Add(time.Hour))
+	test(t, err == ErrInsufficientPoints, "Expected credit is not held, got", err)
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		return ledger.Post(store, tx, ledger.Collect(ledger.Contribution, ledger.Escrow(1), 150, ledger.Player("p1")))
+	})
+	test(t, err == ErrInsufficientPoints, "Expected", ErrInsufficientPoints, "got", err)
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		return ledger.Post(store, tx, ledger.Collect(ledger.Contribution, ledger.Escrow(1), 100, ledger.Player("p1")))
+	})
+	test(t, err == nil, "Expected contribution of 100 points on credit, got", err)
+	funds, err := entry.Funds()
+	test(t, err == nil, "Expected funds of the player, got", err)
+	test(t, funds == Funds{Balance: -100, Credit: 100, Outstanding: 100}, "Expected used credit line, got", funds)
+	err = Delete("p1", store)
+	test(t, err == ErrNonZeroBalance, "Expected", ErrNonZeroBalance, "got", err)
+	audit, err := entry.Audit()
+	test(t, err == nil, "Expected audit of the player, got", err)
+	test(t, len(audit) == 1 && audit[0].Action == "credit limit" && audit[0].Actor == "admin",
+		"Expected credit limit in the audit, got", audit)
+}
@@ -0,0 +1,125 @@
+package player
+
+import (
+	"context"
+	"time"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+// Suspend freezes the player account under investigation, the points could not be spent
+// and could not be credited as well if the credits are frozen,
+// the actor who suspended the account and the reason are kept in the audit
+func (entry *Entry) Suspend(actor, reason string, freezeCredits bool) error {
+	return entry.SuspendContext(context.Background(), actor, reason, freezeCredits)
+}
+
+// SuspendContext freezes the player account under investigation using the context
+func (entry *Entry) SuspendContext(ctx context.Context, actor, reason string, freezeCredits bool) error {
+	return entry.setStatus(ctx, model.Suspended, actor, reason, freezeCredits)
+}
+
+// Activate makes the suspended player account active again,
+// the actor who activated the account and the reason are kept in the audit
+func (entry *Entry) Activate(actor, reason string) error {
+	return entry.ActivateContext(context.Background(), actor, reason)
+}
+
+// ActivateContext makes the suspended player account active again using the context
+func (entry *Entry) ActivateContext(ctx context.Context, actor, reason string) error {
+	return entry.setStatus(ctx, model.Active, actor, reason, false)
+}
+
+// Close closes the player account, the closed account could not be changed anymore,
+// the account should have no points, open holds and stakes in open tournaments,
+// the actor who closed the account and the reason are kept in the audit
+func (entry *Entry) Close(actor, reason string) error {
+	return entry.CloseContext(context.Background(), actor, reason)
+}
+
+// CloseContext closes the player account using the context
+func (entry *Entry) CloseContext(ctx context.Context, actor, reason string) error {
+	return entry.setStatus(ctx, model.Closed, actor, reason, false)
+}
+
+// Status gets current status of the player account and its reason
+func (entry *Entry) Status() (model.Status, string, error) {
+	return entry.StatusContext(context.Background())
+}
+
+// StatusContext gets current status of the player account and its reason using the context
+func (entry *Entry) StatusContext(ctx context.Context) (model.Status, string, error) {
+	var player *model.Player
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		player, err = entry.Controller.FindPlayer(entry.ID(), tx)
+		return err
+	})
+	if err != nil {
+		return "", "", err
+	}
+	if player.Status == "" {
+		return model.Active, player.StatusReason, nil
+	}
+
+	return player.Status, player.StatusReason, nil
+}
+
+// Audit returns changes of the player account in the order they were made
+func (entry *Entry) Audit() ([]model.AuditEntry, error) {
+	return entry.AuditContext(context.Background())
+}
+
+// AuditContext returns changes of the player account using the context
+func (entry *Entry) AuditContext(ctx context.Context) ([]model.AuditEntry, error) {
+	var audit []model.AuditEntry
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) error {
+		if _, err := entry.Controller.FindPlayer(entry.ID(), tx); err != nil {
+			return err
+		}
+		var err error
+		audit, err = entry.Controller.ListAudit(entry.ID(), tx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return audit, nil
+}
+
+// setStatus changes the status of the player account and records the change in the audit
+func (entry *Entry) setStatus(ctx context.Context,
+	status model.Status, actor, reason string, freezeCredits bool) error {
+	return entry.update(ctx, func(tx datastore.Transact) error {
+		player, err := entry.Controller.FindPlayerForUpdate(entry.ID(), tx)
+		if err != nil {
+			return err
+		}
+		if player.Status == model.Closed {
+			return ErrAccountClosed
+		}
+		if status == model.Closed {
+			if err := settled(entry.Controller, tx, player); err != nil {
+				return err
+			}
+		}
+		player.Status = status
+		player.StatusReason = reason
+		player.FrozenCredits = freezeCredits
+		if status == model.Active {
+			player.Status = ""
+			player.StatusReason = ""
+		}
+		if err := entry.Controller.SavePlayer(player, tx); err != nil {
+			return err
+		}
+		return entry.Controller.NewAuditEntry(player.ID, &model.AuditEntry{
+			Action:  "status",
+			Status:  status,
+			Reason:  reason,
+			Actor:   actor,
+			Created: time.Now(),
+		}, tx)
+	})
+}
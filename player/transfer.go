@@ -0,0 +1,78 @@
+package player
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/limits"
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrInvalidTransfer appears if the transfer amount is not positive or the player transfers points to itself
+	ErrInvalidTransfer = errors.New("Transfer should have positive amount and different players")
+	// ErrTransferLimit appears if the transfer amount is out of the transfer limits
+	ErrTransferLimit = errors.New("Transfer amount is out of limits")
+)
+
+// TransferLimits defines minimal and maximal amount of the player-to-player transfer,
+// zero limits are not applied, transfers within the periods are limited by limits.Spend of the sender
+type TransferLimits struct {
+	Min backer.Points
+	Max backer.Points
+}
+
+// Limits defines limits of the player-to-player transfers
+var Limits = TransferLimits{Min: 0.01}
+
+// Transfer moves points from one player to another with the memo atomically,
+// the transfer is kept in the history of both players
+func Transfer(from, to string, amount backer.Points, memo string, ctrl datastore.Controller) error {
+	return TransferContext(context.Background(), from, to, amount, memo, ctrl)
+}
+
+// TransferContext moves points from one player to another using the context,
+// the points are moved once per idempotency key of the context, see datastore.WithIdempotencyKey,
+// and within the spending limits of the sender, see limits.Check
+func TransferContext(ctx context.Context,
+	from, to string, amount backer.Points, memo string, ctrl datastore.Controller) error {
+	if amount <= 0 || from == to {
+		return ErrInvalidTransfer
+	}
+	if amount < Limits.Min || Limits.Max > 0 && amount > Limits.Max {
+		return ErrTransferLimit
+	}
+	request := fmt.Sprintf("transfer:%s:%s:%v:%s", from, to, amount, memo)
+	return datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		_, _, err := datastore.Once(ctx, ctrl, tx, request, func() (string, error) {
+			// players are locked in the same order to avoid deadlocks of opposite transfers
+			first, second := from, to
+			if second < first {
+				first, second = second, first
+			}
+			var sender *model.Player
+			for _, id := range []string{first, second} {
+				player, err := ctrl.FindPlayerForUpdate(id, tx)
+				if err != nil {
+					return "", err
+				}
+				if id == from {
+					sender = player
+				}
+			}
+			if err := limits.Check(ctrl, tx, sender, limits.Spend, amount, time.Now()); err != nil {
+				return "", err
+			}
+			operation := ledger.New(ledger.Transfer, ledger.Player(from), ledger.Player(to), amount)
+			operation.Memo = memo
+			err := ledger.Post(ctrl, tx, operation)
+			return operation.ID, err
+		})
+		return err
+	})
+}
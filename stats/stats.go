@@ -0,0 +1,232 @@
+// Package stats keeps performance statistics of the players as bidders and as backers,
+// the statistics are updated when results of the tournaments are set or reversed
+package stats
+
+import (
+	"sort"
+
+	"github.com/takama/backer"
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/helper"
+	"github.com/takama/backer/model"
+)
+
+// Summary describes performance of the player in the role, profit is returned points minus staked points,
+// ROI is the profit divided by staked points and cash rate is the share of entries with prizes
+type Summary struct {
+	Entries    int
+	Cashes     int
+	Staked     backer.Points
+	Returned   backer.Points
+	Profit     backer.Points
+	ROI        float64
+	CashRate   float64
+	BiggestWin backer.Points
+}
+
+// Report contains performance of the player as a bidder and as a backer
+type Report struct {
+	Player string
+	Bidder Summary
+	Backer Summary
+}
+
+// Summarize returns summary of the performance
+func Summarize(performance model.Performance) Summary {
+	summary := Summary{
+		Entries:    performance.Entries,
+		Cashes:     performance.Cashes,
+		Staked:     performance.Staked,
+		Returned:   performance.Returned,
+		Profit:     round(performance.Returned - performance.Staked),
+		BiggestWin: performance.BiggestWin,
+	}
+	if performance.Staked > 0 {
+		summary.ROI = float64(summary.Profit) / float64(performance.Staked)
+	}
+	if performance.Entries > 0 {
+		summary.CashRate = float64(performance.Cashes) / float64(performance.Entries)
+	}
+	return summary
+}
+
+// Of returns performance report of the player, the player without statistics has an empty report
+func Of(ctrl datastore.Controller, tx datastore.Transact, ID string) (*Report, error) {
+	report := &Report{Player: ID}
+	stats, err := ctrl.FindStats(ID, tx)
+	if err == datastore.ErrRecordNotFound {
+		return report, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Bidder = Summarize(stats.Bidder)
+	report.Backer = Summarize(stats.Backer)
+	return report, nil
+}
+
+// Record adds entries and prizes of the finished tournament to statistics of the players,
+// only tournaments in the default points are counted and removed or archived players are skipped
+func Record(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament) error {
+	return apply(ctrl, tx, tournament, 1)
+}
+
+// Revert removes entries and prizes of the tournament from statistics of the players
+// when its result is reversed, the biggest wins are kept until statistics are rebuilt
+func Revert(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament) error {
+	return apply(ctrl, tx, tournament, -1)
+}
+
+// Rebuild recalculates statistics of the active players from the finished tournaments including archived ones,
+// statistics of the archived players are kept as they are
+func Rebuild(ctrl datastore.Controller) error {
+	return datastore.WithTransaction(ctrl, func(tx datastore.Transact) error {
+		query := datastore.PlayerQuery{}
+		for {
+			players, next, err := ctrl.ListPlayers(query, tx)
+			if err != nil {
+				return err
+			}
+			for _, player := range players {
+				if _, err := ctrl.FindStats(player.ID, tx); err == datastore.ErrRecordNotFound {
+					continue
+				} else if err != nil {
+					return err
+				}
+				if _, err := ctrl.FindPlayerForUpdate(player.ID, tx); err != nil {
+					return err
+				}
+				if err := ctrl.SaveStats(player.ID, new(model.Stats), tx); err != nil {
+					return err
+				}
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+		for _, archived := range []bool{false, true} {
+			query := datastore.TournamentQuery{State: datastore.Finished, Archived: archived}
+			for {
+				tournaments, next, err := ctrl.ListTournaments(query, tx)
+				if err != nil {
+					return err
+				}
+				for idx := range tournaments {
+					if err := Record(ctrl, tx, &tournaments[idx]); err != nil {
+						return err
+					}
+				}
+				if next == "" {
+					break
+				}
+				query.Cursor = next
+			}
+		}
+		return nil
+	})
+}
+
+// Shares returns changes of statistics of the players made by the finished tournament,
+// the deposit and the prize of the entry are shared equally by the bidder and its backers
+func Shares(tournament *model.Tournament) map[string]*model.Stats {
+	changes := make(map[string]*model.Stats)
+	for _, bidder := range tournament.Bidders {
+		participants := append([]string{bidder.ID}, bidder.Backers...)
+		share := backer.Points(len(participants))
+		stake := truncate(tournament.Deposit / share)
+		var prize backer.Points
+		if bidder.Winner {
+			prize = truncate(bidder.Prize / share)
+		}
+		for idx, ID := range participants {
+			if changes[ID] == nil {
+				changes[ID] = new(model.Stats)
+			}
+			performance := &changes[ID].Backer
+			if idx == 0 {
+				performance = &changes[ID].Bidder
+			}
+			performance.Entries++
+			performance.Staked = round(performance.Staked + stake)
+			performance.Returned = round(performance.Returned + prize)
+			if prize > 0 {
+				performance.Cashes++
+			}
+			if prize > performance.BiggestWin {
+				performance.BiggestWin = prize
+			}
+		}
+	}
+	return changes
+}
+
+// apply adds the tournament to statistics of the players with the sign
+func apply(ctrl datastore.Controller, tx datastore.Transact, tournament *model.Tournament, sign int) error {
+	if tournament.Currency != "" {
+		return nil
+	}
+	changes := Shares(tournament)
+	if sign < 0 {
+		for _, change := range changes {
+			negate(&change.Bidder)
+			negate(&change.Backer)
+		}
+	}
+	// players are locked in the same order to avoid deadlocks
+	IDs := make([]string, 0, len(changes))
+	for ID := range changes {
+		IDs = append(IDs, ID)
+	}
+	sort.Strings(IDs)
+	for _, ID := range IDs {
+		_, err := ctrl.FindPlayerForUpdate(ID, tx)
+		if err == datastore.ErrRecordNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		stats, err := ctrl.FindStats(ID, tx)
+		if err == datastore.ErrRecordNotFound {
+			stats, err = new(model.Stats), nil
+		}
+		if err != nil {
+			return err
+		}
+		merge(&stats.Bidder, changes[ID].Bidder)
+		merge(&stats.Backer, changes[ID].Backer)
+		if err := ctrl.SaveStats(ID, stats, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// merge adds the change to the performance
+func merge(performance *model.Performance, change model.Performance) {
+	performance.Entries += change.Entries
+	performance.Cashes += change.Cashes
+	performance.Staked = round(performance.Staked + change.Staked)
+	performance.Returned = round(performance.Returned + change.Returned)
+	if change.BiggestWin > performance.BiggestWin {
+		performance.BiggestWin = change.BiggestWin
+	}
+}
+
+// negate turns the change of the performance into its removal keeping the biggest win
+func negate(performance *model.Performance) {
+	performance.Entries = -performance.Entries
+	performance.Cashes = -performance.Cashes
+	performance.Staked = -performance.Staked
+	performance.Returned = -performance.Returned
+	performance.BiggestWin = 0
+}
+
+func truncate(amount backer.Points) backer.Points {
+	return backer.Points(helper.TruncatePrice(float32(amount)))
+}
+
+func round(amount backer.Points) backer.Points {
+	return backer.Points(helper.RoundPrice(float32(amount)))
+}
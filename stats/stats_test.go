@@ -0,0 +1,81 @@
+package stats
+
+import (
+	"testing"
+
+	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/model"
+)
+
+func test(t *testing.T, expected bool, messages ...interface{}) {
+	if !expected {
+		t.Error(messages...)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+
+	summary := Summarize(model.Performance{Entries: 4, Cashes: 1, Staked: 200, Returned: 300, BiggestWin: 300})
+	test(t, summary.Profit == 100 && summary.ROI == 0.5 && summary.CashRate == 0.25,
+		"Expected profit 100, ROI 0.5 and cash rate 0.25, got", summary)
+	summary = Summarize(model.Performance{})
+	test(t, summary == Summary{}, "Expected empty summary, got", summary)
+}
+
+func TestRecord(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	for _, id := range []string{"p1", "p2", "b1"} {
+		store.NewPlayer(id, nil)
+	}
+	tournaments := []model.Tournament{
+		{ID: 1, Deposit: 300, IsFinished: true, Bidders: []model.Bidder{
+			{ID: "p1", Winner: true, Prize: 900, Backers: []string{"b1", "b2"}},
+			{ID: "p2"},
+		}},
+		{ID: 2, Deposit: 100, IsFinished: true, Bidders: []model.Bidder{
+			{ID: "p2", Winner: true, Prize: 150, Backers: []string{"b1"}},
+			{ID: "p1"},
+		}},
+		{ID: 3, Deposit: 100, Currency: "tickets", IsFinished: true, Bidders: []model.Bidder{
+			{ID: "p1", Winner: true, Prize: 500},
+		}},
+	}
+	for idx := range tournaments {
+		store.NewTournament(tournaments[idx].ID, nil)
+		tournament, err := store.FindTournament(tournaments[idx].ID, nil)
+		test(t, err == nil, "Expected find the tournament, got", err)
+		tournaments[idx].Version = tournament.Version
+		err = store.SaveTournament(&tournaments[idx], nil)
+		test(t, err == nil, "Expected save the tournament, got", err)
+		err = Record(store, nil, &tournaments[idx])
+		test(t, err == nil, "Expected record of the tournament, got", err)
+	}
+
+	check := func() {
+		report, err := Of(store, nil, "p1")
+		test(t, err == nil, "Expected report of the player, got", err)
+		test(t, report.Bidder == Summary{
+			Entries: 2, Cashes: 1, Staked: 200, Returned: 300, Profit: 100, ROI: 0.5, CashRate: 0.5, BiggestWin: 300,
+		}, "Expected bidder performance of the player, got", report.Bidder)
+		test(t, report.Backer == Summary{}, "Expected no backer performance of the player, got", report.Backer)
+		report, err = Of(store, nil, "b1")
+		test(t, err == nil, "Expected report of the player, got", err)
+		test(t, report.Backer.Entries == 2 && report.Backer.Staked == 150 && report.Backer.Returned == 375 &&
+			report.Backer.BiggestWin == 300 && report.Backer.CashRate == 1,
+			"Expected backer performance of the player, got", report.Backer)
+	}
+	check()
+
+	err := Revert(store, nil, &tournaments[1])
+	test(t, err == nil, "Expected revert of the tournament, got", err)
+	report, err := Of(store, nil, "p2")
+	test(t, err == nil, "Expected report of the player, got", err)
+	test(t, report.Bidder.Entries == 1 && report.Bidder.Staked == 300 && report.Bidder.Returned == 0,
+		"Expected reverted performance of the player, got", report.Bidder)
+
+	err = Rebuild(store)
+	test(t, err == nil, "Expected rebuild of the statistics, got", err)
+	check()
+}
@@ -0,0 +1,79 @@
+package tournament
+
+import (
+	"errors"
+
+	"github.com/takama/backer/model"
+)
+
+var (
+	// ErrSelfBacking appears if the player backs itself
+	ErrSelfBacking = errors.New("Player could not back itself")
+	// ErrDuplicateBacker appears if the same backer is passed twice for the player
+	ErrDuplicateBacker = errors.New("Backer could not back the same player twice")
+	// ErrBackingOpponents appears if the backer backs multiple bidders in the same tournament
+	// while it is banned by the rules
+	ErrBackingOpponents = errors.New("Backer could not back opponents in the same tournament")
+	// ErrBlockedBacker appears if the backer and the player are blocked from each other
+	ErrBlockedBacker = errors.New("Backer is blocked for the player")
+)
+
+// Rules defines backer relationship rules validated when players join the tournament,
+// self-backing and duplicate backers are never allowed
+type Rules struct {
+	// SingleBidder bans backing multiple bidders in the same tournament,
+	// a bidder could not back its opponents as well
+	SingleBidder bool
+	// Blocklist contains pairs of players who could not back each other
+	Blocklist [][2]string
+}
+
+// DefaultRules defines rules of the tournaments which are announced without own rules,
+// the rules are kept with the tournament when it is announced, so later changes do not affect it
+var DefaultRules Rules
+
+// Block adds the pair of players who could not back each other to the blocklist
+func (rules *Rules) Block(first, second string) {
+	rules.Blocklist = append(rules.Blocklist, [2]string{first, second})
+}
+
+// Blocked reports whether the players could not back each other
+func (rules *Rules) Blocked(first, second string) bool {
+	for _, pair := range rules.Blocklist {
+		if pair[0] == first && pair[1] == second || pair[0] == second && pair[1] == first {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the bidder with backers which joins the tournament against the rules
+func (rules *Rules) Validate(tournament *model.Tournament, bidder model.Bidder) error {
+	seen := make(map[string]bool, len(bidder.Backers))
+	for _, backer := range bidder.Backers {
+		if backer == bidder.ID {
+			return ErrSelfBacking
+		}
+		if seen[backer] {
+			return ErrDuplicateBacker
+		}
+		seen[backer] = true
+		if rules.Blocked(bidder.ID, backer) {
+			return ErrBlockedBacker
+		}
+	}
+	if !rules.SingleBidder {
+		return nil
+	}
+	for _, member := range tournament.Bidders {
+		if seen[member.ID] {
+			return ErrBackingOpponents
+		}
+		for _, backer := range member.Backers {
+			if seen[backer] || backer == bidder.ID {
+				return ErrBackingOpponents
+			}
+		}
+	}
+	return nil
+}
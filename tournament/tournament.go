@@ -1,14 +1,22 @@
 package tournament
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/takama/backer"
 	"github.com/takama/backer/datastore"
 	"github.com/takama/backer/helper"
+	"github.com/takama/backer/leaderboard"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/limits"
 	"github.com/takama/backer/model"
-	"github.com/takama/backer/player"
+	"github.com/takama/backer/stats"
 )
 
 var (
@@ -20,37 +28,48 @@ var (
 	ErrCouldNotJoinTwice = errors.New("Could not join twice to the same tournament")
 	// ErrWinnerIsNotMember appears if among winners exists a player who not a tournament member as a player
 	ErrWinnerIsNotMember = errors.New("Not a tournament player can not be a winner")
+	// ErrOpenStakes appears if the tournament could not be removed while players already joined and it is not finished
+	ErrOpenStakes = errors.New("Could not remove the Tournament, players already joined")
+	// ErrNotFinished appears if the result of the tournament which is not finished is reversed
+	ErrNotFinished = errors.New("Tournament is not finished")
+	// ErrWrongCurrency appears if the players pay in other currency than the tournament deposit
+	ErrWrongCurrency = errors.New("Tournament deposit uses other currency")
 )
 
-// Entry implements Tournament interface
+// Entry implements Tournament interface,
+// backer relationship rules of the entry (or DefaultRules if they are not set) are kept with the tournament
+// when it is announced and Join validates the players against the kept rules
 type Entry struct {
 	datastore.Controller `json:"-"`
+	Rules                *Rules `json:"-"`
 	mutex                sync.RWMutex
 	model.Tournament
 }
 
 // New returns new Entry which implement Tournament interface
 func New(id uint64, ctrl datastore.Controller) (*Entry, error) {
-	tx, err := ctrl.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
+	return NewContext(context.Background(), id, ctrl)
+}
 
+// NewContext returns new Entry which implement Tournament interface using the context
+func NewContext(ctx context.Context, id uint64, ctrl datastore.Controller) (*Entry, error) {
 	entry := &Entry{Controller: ctrl}
 
-	tournament, err := ctrl.FindTournament(id, tx)
-	if err != nil {
-		err = ctrl.NewTournament(id, tx)
+	err := datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		tournament, err := ctrl.FindTournament(id, tx)
 		if err != nil {
-			tx.Rollback()
-			return nil, err
+			err = ctrl.NewTournament(id, tx)
+			if err != nil {
+				return err
+			}
+			tournament, err = ctrl.FindTournament(id, tx)
+			if err != nil {
+				return err
+			}
 		}
-		tournament = &model.Tournament{ID: id, Bidders: make([]model.Bidder, 0)}
-	}
-	entry.Tournament = *tournament
-
-	err = tx.Commit()
+		entry.Tournament = *tournament
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -60,22 +79,21 @@ func New(id uint64, ctrl datastore.Controller) (*Entry, error) {
 
 // Find returns Entry with existing Tournament
 func Find(id uint64, ctrl datastore.Controller) (*Entry, error) {
-	tx, err := ctrl.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
+	return FindContext(context.Background(), id, ctrl)
+}
 
+// FindContext returns Entry with existing Tournament using the context
+func FindContext(ctx context.Context, id uint64, ctrl datastore.Controller) (*Entry, error) {
 	entry := &Entry{Controller: ctrl}
 
-	tournament, err := ctrl.FindTournament(id, tx)
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-	entry.Tournament = *tournament
-
-	err = tx.Commit()
+	err := datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		tournament, err := ctrl.FindTournament(id, tx)
+		if err != nil {
+			return err
+		}
+		entry.Tournament = *tournament
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -83,36 +101,100 @@ func Find(id uint64, ctrl datastore.Controller) (*Entry, error) {
 	return entry, nil
 }
 
+// Delete removes existing tournament,
+// the tournament should be finished or should not have joined players
+func Delete(id uint64, ctrl datastore.Controller) error {
+	return DeleteContext(context.Background(), id, ctrl)
+}
+
+// DeleteContext removes existing tournament using the context
+func DeleteContext(ctx context.Context, id uint64, ctrl datastore.Controller) error {
+	return remove(ctx, id, ctrl, ctrl.DeleteTournament)
+}
+
+// Archive hides existing tournament until it is restored,
+// the tournament should be finished or should not have joined players
+func Archive(id uint64, ctrl datastore.Controller) error {
+	return ArchiveContext(context.Background(), id, ctrl)
+}
+
+// ArchiveContext hides existing tournament using the context
+func ArchiveContext(ctx context.Context, id uint64, ctrl datastore.Controller) error {
+	return remove(ctx, id, ctrl, ctrl.ArchiveTournament)
+}
+
+// Restore returns archived tournament back
+func Restore(id uint64, ctrl datastore.Controller) error {
+	return RestoreContext(context.Background(), id, ctrl)
+}
+
+// RestoreContext returns archived tournament back using the context
+func RestoreContext(ctx context.Context, id uint64, ctrl datastore.Controller) error {
+	return datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		return ctrl.RestoreTournament(id, tx)
+	})
+}
+
+func remove(ctx context.Context, id uint64, ctrl datastore.Controller,
+	action func(ID uint64, tx datastore.Transact) error) error {
+	return datastore.WithTransactionContext(ctx, ctrl, func(tx datastore.Transact) error {
+		tournament, err := ctrl.FindTournamentForUpdate(id, tx)
+		if err != nil {
+			return err
+		}
+
+		if !tournament.IsFinished && len(tournament.Bidders) > 0 {
+			return ErrOpenStakes
+		}
+
+		return action(id, tx)
+	})
+}
+
 // Announce tournament with specified deposit
 func (entry *Entry) Announce(deposit backer.Points) error {
-	tx, err := entry.Controller.Transaction()
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return entry.AnnounceContext(context.Background(), deposit)
+}
 
-	tournament, err := entry.Controller.FindTournament(entry.Tournament.ID, tx)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+// AnnounceContext announces tournament with specified deposit using the context
+func (entry *Entry) AnnounceContext(ctx context.Context, deposit backer.Points) error {
+	return entry.AnnounceInContext(ctx, "", deposit)
+}
 
-	if tournament.IsFinished {
-		return ErrAllreadyFinished
-	}
+// AnnounceIn announces tournament with specified deposit in the currency,
+// the empty currency means the default points
+func (entry *Entry) AnnounceIn(currency string, deposit backer.Points) error {
+	return entry.AnnounceInContext(context.Background(), currency, deposit)
+}
 
-	if len(tournament.Bidders) > 0 {
-		return ErrPlayersAlreadyJoined
-	}
+// AnnounceInContext announces tournament with specified deposit in the currency using the context
+func (entry *Entry) AnnounceInContext(ctx context.Context, currency string, deposit backer.Points) error {
+	var tournament *model.Tournament
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		tournament, err = entry.Controller.FindTournamentForUpdate(entry.id(), tx)
+		if err != nil {
+			return err
+		}
 
-	tournament.Deposit = backer.Points(helper.TruncatePrice(float32(deposit)))
-	err = entry.Controller.SaveTournament(tournament, tx)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		if tournament.IsFinished {
+			return ErrAllreadyFinished
+		}
 
-	err = tx.Commit()
+		if len(tournament.Bidders) > 0 {
+			return ErrPlayersAlreadyJoined
+		}
+
+		rules := DefaultRules
+		if entry.Rules != nil {
+			rules = *entry.Rules
+		}
+		rules.Blocklist = append([][2]string(nil), rules.Blocklist...)
+		backerRules := model.BackerRules(rules)
+		tournament.Deposit = backer.Points(helper.TruncatePrice(float32(deposit)))
+		tournament.Currency = currency
+		tournament.BackerRules = &backerRules
+		return entry.Controller.SaveTournament(tournament, tx)
+	})
 	if err != nil {
 		return err
 	}
@@ -120,109 +202,226 @@ func (entry *Entry) Announce(deposit backer.Points) error {
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
 	entry.Tournament.Deposit = tournament.Deposit
+	entry.Tournament.Currency = tournament.Currency
 	entry.Tournament.Bidders = tournament.Bidders
+	entry.Tournament.BackerRules = tournament.BackerRules
 
 	return nil
 }
 
-// Join player and backers into a tournament
+// Join player and backers into a tournament,
+// the whole operation is repeated up to datastore.MaxRetries times on conflicts and deadlocks
 func (entry *Entry) Join(players ...backer.Player) error {
-	tx, err := entry.Controller.Transaction()
+	return entry.JoinContext(context.Background(), players...)
+}
+
+// JoinContext joins player and backers into a tournament using the context,
+// the players are joined once per idempotency key of the context, see datastore.WithIdempotencyKey
+func (entry *Entry) JoinContext(ctx context.Context, players ...backer.Player) error {
+	return entry.join(ctx, nil, players)
+}
+
+// JoinIn joins player and backers who pay in the currency into a tournament,
+// the players are rejected if the tournament deposit uses other currency
+func (entry *Entry) JoinIn(currency string, players ...backer.Player) error {
+	return entry.JoinInContext(context.Background(), currency, players...)
+}
+
+// JoinInContext joins player and backers who pay in the currency into a tournament using the context
+func (entry *Entry) JoinInContext(ctx context.Context, currency string, players ...backer.Player) error {
+	return entry.join(ctx, &currency, players)
+}
+
+// join joins the players once per idempotency key of the context,
+// the players pay in the tournament currency if the currency is nil
+func (entry *Entry) join(ctx context.Context, currency *string, players []backer.Player) error {
+	ids := make([]string, 0, len(players))
+	for _, participant := range players {
+		ids = append(ids, participant.ID())
+	}
+	request := fmt.Sprintf("join:%d:%s", entry.id(), strings.Join(ids, ","))
+	var tournament *model.Tournament
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		_, replayed, err := datastore.Once(ctx, entry.Controller, tx, request, func() (string, error) {
+			tournament, err = entry.contribute(tx, currency, players)
+			return "", err
+		})
+		if err != nil || !replayed {
+			return err
+		}
+		tournament, err = entry.Controller.FindTournament(entry.id(), tx)
+		return err
+	})
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
-	tournament, err := entry.Controller.FindTournament(entry.Tournament.ID, tx)
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
+	entry.Tournament.Bidders = tournament.Bidders
+
+	return nil
+}
+
+func (entry *Entry) contribute(tx datastore.Transact,
+	currency *string, players []backer.Player) (*model.Tournament, error) {
+	tournament, err := entry.Controller.FindTournamentForUpdate(entry.id(), tx)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
 	if tournament.IsFinished {
-		tx.Rollback()
-		return ErrAllreadyFinished
+		return nil, ErrAllreadyFinished
+	}
+
+	if currency != nil && *currency != tournament.Currency {
+		return nil, ErrWrongCurrency
 	}
 
 	var bidder model.Bidder
 	bidder.Backers = make([]string, 0)
-	contribute := float32(tournament.Deposit / backer.Points(len(players)))
+	accounts := make([]string, 0, len(players))
+	members := make([]*model.Player, 0, len(players))
 	for idx, participant := range players {
-		if _, err := player.ManagePoints(entry.Controller, tx,
-			participant.ID(), backer.Points(-contribute)); err != nil {
-			tx.Rollback()
-			return err
-		}
 		if idx == 0 {
 			for _, member := range tournament.Bidders {
 				if member.ID == participant.ID() {
-					tx.Rollback()
-					return ErrCouldNotJoinTwice
+					return nil, ErrCouldNotJoinTwice
 				}
 			}
 			bidder.ID = participant.ID()
 		} else {
 			bidder.Backers = append(bidder.Backers, participant.ID())
 		}
+		// the member is locked before the checks, so concurrent joins could not exceed its limits
+		member, err := entry.Controller.FindPlayerForUpdate(participant.ID(), tx)
+		if err != nil {
+			return nil, err
+		}
+		if err := ledger.CheckStatus(member); err != nil {
+			return nil, err
+		}
+		if limits.Excluded(member, time.Now()) {
+			return nil, limits.ErrSelfExcluded
+		}
+		members = append(members, member)
+		accounts = append(accounts, ledger.Player(participant.ID()))
+	}
+	// tournaments which were not announced have no kept rules
+	rules := DefaultRules
+	if tournament.BackerRules != nil {
+		rules = Rules(*tournament.BackerRules)
+	}
+	if err := rules.Validate(tournament, bidder); err != nil {
+		return nil, err
+	}
+	contribution := ledger.Collect(ledger.Contribution, ledger.Escrow(tournament.ID),
+		tournament.Deposit/backer.Points(len(players)), accounts...)
+	contribution.Tournament = tournament.ID
+	contribution.Currency = tournament.Currency
+	if tournament.Currency == "" {
+		now := time.Now()
+		for idx, member := range members {
+			category := limits.Stake
+			if idx == 0 {
+				category = limits.Spend
+			}
+			err := limits.Check(entry.Controller, tx, member, category, -contribution.Postings[idx].Amount, now)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := ledger.Post(entry.Controller, tx, contribution); err != nil {
+		return nil, err
 	}
 	tournament.Bidders = append(tournament.Bidders, bidder)
 
-	err = entry.Controller.SaveTournament(tournament, tx)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return tournament, entry.Controller.SaveTournament(tournament, tx)
+}
 
-	err = tx.Commit()
+// Result tournament prizes and winners,
+// the whole operation is repeated up to datastore.MaxRetries times on conflicts and deadlocks
+func (entry *Entry) Result(winners map[backer.Player]backer.Points) error {
+	return entry.ResultContext(context.Background(), winners)
+}
+
+// ResultContext sets tournament prizes and winners using the context,
+// the result is set once per idempotency key of the context, see datastore.WithIdempotencyKey
+func (entry *Entry) ResultContext(ctx context.Context, winners map[backer.Player]backer.Points) error {
+	origin := make(map[backer.Player]backer.Points, len(winners))
+	prizes := make([]string, 0, len(winners))
+	for winner, points := range winners {
+		origin[winner] = points
+		prizes = append(prizes, fmt.Sprintf("%s=%v", winner.ID(), points))
+	}
+	sort.Strings(prizes)
+	request := fmt.Sprintf("result:%d:%s", entry.id(), strings.Join(prizes, ","))
+	var tournament *model.Tournament
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		for winner, points := range origin {
+			winners[winner] = points
+		}
+		_, replayed, err := datastore.Once(ctx, entry.Controller, tx, request, func() (string, error) {
+			tournament, err = entry.result(tx, winners)
+			return "", err
+		})
+		if err != nil || !replayed {
+			return err
+		}
+		tournament, err = entry.Controller.FindTournament(entry.id(), tx)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
 	entry.mutex.Lock()
 	defer entry.mutex.Unlock()
+	entry.Tournament.IsFinished = tournament.IsFinished
+	entry.Tournament.Finished = tournament.Finished
 	entry.Tournament.Bidders = tournament.Bidders
 
 	return nil
 }
 
-// Result tournament prizes and winners
-func (entry *Entry) Result(winners map[backer.Player]backer.Points) error {
-	tx, err := entry.Controller.Transaction()
+func (entry *Entry) result(tx datastore.Transact, winners map[backer.Player]backer.Points) (*model.Tournament, error) {
+	tournament, err := entry.Controller.FindTournamentForUpdate(entry.id(), tx)
 	if err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	tournament, err := entry.Controller.FindTournament(entry.Tournament.ID, tx)
-	if err != nil {
-		tx.Rollback()
-		return err
+		return nil, err
 	}
 
 	if tournament.IsFinished {
-		tx.Rollback()
-		return ErrAllreadyFinished
+		return nil, ErrAllreadyFinished
 	}
 
-	entry.mutex.Lock()
-	defer entry.mutex.Unlock()
-
 	for winner, points := range winners {
 		for idx, bidder := range tournament.Bidders {
 			if bidder.ID == winner.ID() {
 				tournament.Bidders[idx].Winner = true
 				tournament.Bidders[idx].Prize = points
-				prize := float32(points / backer.Points(len(bidder.Backers)+1))
-				if _, err := player.ManagePoints(entry.Controller, tx,
-					winner.ID(), backer.Points(prize)); err != nil {
-					tx.Rollback()
-					return err
+				// shares of the accounts which could not be credited stay in the escrow
+				// and are settled with the house by the fee
+				members := append([]string{bidder.ID}, bidder.Backers...)
+				accounts := make([]string, 0, len(members))
+				for _, id := range members {
+					member, err := entry.Controller.FindPlayerForUpdate(id, tx)
+					if err != nil {
+						return nil, err
+					}
+					if ledger.CheckCredit(member) != nil {
+						tournament.Bidders[idx].Withheld = append(tournament.Bidders[idx].Withheld, id)
+						continue
+					}
+					accounts = append(accounts, ledger.Player(id))
 				}
-				for _, id := range bidder.Backers {
-					if _, err := player.ManagePoints(entry.Controller, tx,
-						id, backer.Points(prize)); err != nil {
-						tx.Rollback()
-						return err
+				if len(accounts) > 0 {
+					prize := ledger.Distribute(ledger.Prize, ledger.Escrow(tournament.ID),
+						points/backer.Points(len(members)), accounts...)
+					prize.Tournament = tournament.ID
+					prize.Currency = tournament.Currency
+					if err := ledger.Post(entry.Controller, tx, prize); err != nil {
+						return nil, err
 					}
 				}
 				delete(winners, winner)
@@ -231,24 +430,115 @@ func (entry *Entry) Result(winners map[backer.Player]backer.Points) error {
 	}
 
 	if len(winners) != 0 {
-		tx.Rollback()
-		return ErrWinnerIsNotMember
+		return nil, ErrWinnerIsNotMember
 	}
-	tournament.IsFinished = true
 
-	err = entry.Controller.SaveTournament(tournament, tx)
+	rest, err := ledger.BalanceIn(entry.Controller, tx, ledger.Escrow(tournament.ID), tournament.Currency)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return nil, err
+	}
+	if rest != 0 {
+		fee := ledger.New(ledger.Fee, ledger.Escrow(tournament.ID), ledger.House, rest)
+		fee.Tournament = tournament.ID
+		fee.Currency = tournament.Currency
+		if err := ledger.Post(entry.Controller, tx, fee); err != nil {
+			return nil, err
+		}
+	}
+	tournament.IsFinished = true
+	tournament.Finished = time.Now()
+	if err := stats.Record(entry.Controller, tx, tournament); err != nil {
+		return nil, err
+	}
+	if err := leaderboard.Record(entry.Controller, tx, tournament); err != nil {
+		return nil, err
 	}
 
-	err = tx.Commit()
+	return tournament, entry.Controller.SaveTournament(tournament, tx)
+}
+
+// ReverseResult claws back prizes of the finished tournament from winners and backers
+// according to the policy, reverses the fee and opens the tournament again for the correct result,
+// the actor who reversed the result and the reason are kept in the reversals
+func (entry *Entry) ReverseResult(actor, reason string, policy ledger.Policy) error {
+	return entry.ReverseResultContext(context.Background(), actor, reason, policy)
+}
+
+// ReverseResultContext reverses the result of the finished tournament using the context
+func (entry *Entry) ReverseResultContext(ctx context.Context,
+	actor, reason string, policy ledger.Policy) error {
+	var tournament *model.Tournament
+	err := datastore.WithTransactionContext(ctx, entry.Controller, func(tx datastore.Transact) (err error) {
+		tournament, err = entry.reverseResult(tx, actor, reason, policy)
+		return err
+	})
 	if err != nil {
 		return err
 	}
 
+	entry.mutex.Lock()
+	defer entry.mutex.Unlock()
 	entry.Tournament.IsFinished = tournament.IsFinished
+	entry.Tournament.Finished = tournament.Finished
 	entry.Tournament.Bidders = tournament.Bidders
 
 	return nil
 }
+
+func (entry *Entry) reverseResult(tx datastore.Transact,
+	actor, reason string, policy ledger.Policy) (*model.Tournament, error) {
+	tournament, err := entry.Controller.FindTournamentForUpdate(entry.id(), tx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !tournament.IsFinished {
+		return nil, ErrNotFinished
+	}
+
+	if err := stats.Revert(entry.Controller, tx, tournament); err != nil {
+		return nil, err
+	}
+	if err := leaderboard.Revert(entry.Controller, tx, tournament); err != nil {
+		return nil, err
+	}
+	tournament.IsFinished = false
+	tournament.Finished = time.Time{}
+	for idx := range tournament.Bidders {
+		tournament.Bidders[idx].Winner = false
+		tournament.Bidders[idx].Prize = 0
+		tournament.Bidders[idx].Withheld = nil
+	}
+	if err := entry.Controller.SaveTournament(tournament, tx); err != nil {
+		return nil, err
+	}
+
+	for _, kind := range []string{ledger.Prize, ledger.Fee} {
+		query := datastore.OperationQuery{Kind: kind, Tournament: tournament.ID}
+		for {
+			operations, next, err := entry.Controller.ListOperations(query, tx)
+			if err != nil {
+				return nil, err
+			}
+			for _, operation := range operations {
+				_, err := ledger.Reverse(entry.Controller, tx, operation.ID, actor, reason, policy)
+				if err != nil && err != ledger.ErrAlreadyReversed {
+					return nil, err
+				}
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+	}
+
+	return tournament, nil
+}
+
+// id returns tournament ID
+func (entry *Entry) id() uint64 {
+	entry.mutex.RLock()
+	defer entry.mutex.RUnlock()
+	return entry.Tournament.ID
+}
@@ -1,11 +1,20 @@
 package tournament
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/takama/backer"
 	"github.com/takama/backer/datastore"
+	"github.com/takama/backer/datastore/redistest"
+	"github.com/takama/backer/leaderboard"
+	"github.com/takama/backer/ledger"
+	"github.com/takama/backer/limits"
+	"github.com/takama/backer/model"
 	"github.com/takama/backer/player"
 )
 
@@ -20,7 +29,7 @@ var (
 
 func test(t *testing.T, expected bool, messages ...interface{}) {
 	if !expected {
-		t.Error(messages)
+		t.Error(messages...)
 	}
 }
 
@@ -96,6 +105,12 @@ func TestTournamentAnnounce(t *testing.T) {
 	test(t, err == nil, "Expected join a player, got", err)
 	err = tournament.Announce(1000)
 	test(t, err == ErrPlayersAlreadyJoined, "Expected disable to re-announce of the tournament, got", err)
+	playerP2, err := player.New("p2", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP2.Fund(1000)
+	test(t, err == nil, "Expected fund 1000 to the player, got", err)
+	err = tournament.Join(playerP2)
+	test(t, err == nil, "Expected join a player after the failed announce, got", err)
 	tournament, err = New(2, store)
 	test(t, err == nil, "Expected creating a new tournament, got", err)
 	store.ErrTx = append(store.ErrTx, ErrFalseTransaction)
@@ -113,6 +128,8 @@ func TestTournamentAnnounce(t *testing.T) {
 	err = tournament.Result(nil)
 	err = tournament.Announce(700)
 	test(t, err == ErrAllreadyFinished, "Expected", ErrAllreadyFinished, "got", err)
+	err = Archive(2, store)
+	test(t, err == nil, "Expected archive the tournament after the failed announce, got", err)
 }
 
 func TestTournamentJoin(t *testing.T) {
@@ -405,3 +422,809 @@ func TestTournamentResult(t *testing.T) {
 	test(t, err == nil, "Expected check balance of the player, got", err)
 	test(t, balance == 833.33, "Expected 833.33 points for the player, got", balance)
 }
+
+// racyStub changes the tournament concurrently right after it was found
+type racyStub struct {
+	*datastore.Stub
+	races int
+}
+
+func (stub *racyStub) FindTournamentForUpdate(ID uint64, tx datastore.Transact) (*model.Tournament, error) {
+	tournament, err := stub.Stub.FindTournamentForUpdate(ID, tx)
+	if err == nil && stub.races > 0 {
+		stub.races--
+		concurrent := *tournament
+		stub.Stub.SaveTournament(&concurrent, tx)
+	}
+	return tournament, err
+}
+
+func TestTournamentConflict(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	racy := &racyStub{Stub: store}
+	playerP1, err := player.New("p1", racy)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	playerB1, err := player.New("b1", racy)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP1.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	err = playerB1.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	tournament, err := New(1, racy)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(1000)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+
+	racy.races = 2
+	err = tournament.Join(playerP1, playerB1)
+	test(t, err == nil, "Expected join players, got", err)
+	test(t, len(tournament.Bidders) == 1, "Expected 1 bidder, got", len(tournament.Bidders))
+	balance, err := playerP1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 0, "Expected 0 points for the player, got", balance)
+	balance, err = playerB1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 0, "Expected 0 points for the player, got", balance)
+
+	racy.races = 1
+	winners := map[backer.Player]backer.Points{playerP1: 3000}
+	err = tournament.Result(winners)
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	balance, err = playerP1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1500, "Expected 1500 points for the player, got", balance)
+	balance, err = playerB1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1500, "Expected 1500 points for the player, got", balance)
+
+	tournament, err = New(2, racy)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	racy.races = datastore.MaxRetries + 1
+	err = tournament.Announce(0)
+	test(t, datastore.IsConflict(err), "Expected conflict error, got", err)
+}
+
+func TestTournamentConcurrentJoin(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	playerA, err := player.New("a", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	playerB, err := player.New("b", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerA.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	err = playerB.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for id := uint64(1); id <= 10; id++ {
+		tournament, err := New(id, store)
+		test(t, err == nil, "Expected creating a new tournament, got", err)
+		err = tournament.Announce(200)
+		test(t, err == nil, "Expected announce of the tournament, got", err)
+		participants := []backer.Player{playerA, playerB}
+		if id%2 == 0 {
+			participants = []backer.Player{playerB, playerA}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tournament.Join(participants...)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	joined := 0
+	for err := range errs {
+		if err == nil {
+			joined++
+			continue
+		}
+		test(t, err == player.ErrInsufficientPoints || datastore.IsRetryable(err),
+			"Expected insufficient points or retryable error, got", err)
+	}
+	test(t, joined <= 5, "Expected at most 5 joins, got", joined)
+	balance, err := playerA.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == backer.Points(500-100*joined), "Expected", 500-100*joined, "points, got", balance)
+	balance, err = playerB.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == backer.Points(500-100*joined), "Expected", 500-100*joined, "points, got", balance)
+}
+
+// slowStore delays results of the operations listing to widen the window between the limit check and the posting
+type slowStore struct {
+	*datastore.Stub
+}
+
+func (store slowStore) ListOperations(query datastore.OperationQuery,
+	tx datastore.Transact) ([]model.Operation, string, error) {
+	operations, next, err := store.Stub.ListOperations(query, tx)
+	time.Sleep(10 * time.Millisecond)
+	return operations, next, err
+}
+
+func TestTournamentConcurrentLimits(t *testing.T) {
+
+	store := slowStore{new(datastore.Stub)}
+	store.Reset()
+	backerB1, err := player.New("b1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = backerB1.Fund(1000)
+	test(t, err == nil, "Expected fund 1000 to the player, got", err)
+	err = backerB1.SetLimits("b1", model.Limits{Stake: model.Periods{Daily: 100}})
+	test(t, err == nil, "Expected set limits of the backer, got", err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for id := uint64(1); id <= 10; id++ {
+		bidder, err := player.New(fmt.Sprintf("p%d", id), store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = bidder.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		tournament, err := New(id, store)
+		test(t, err == nil, "Expected creating a new tournament, got", err)
+		err = tournament.Announce(120)
+		test(t, err == nil, "Expected announce of the tournament, got", err)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- tournament.Join(bidder, backerB1)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	joined := 0
+	for err := range errs {
+		if err == nil {
+			joined++
+			continue
+		}
+		test(t, limits.IsLimit(err) || datastore.IsRetryable(err), "Expected limit or retryable error, got", err)
+	}
+	test(t, joined == 1, "Expected exactly 1 join within the stake limit, got", joined)
+	balance, err := backerB1.Balance()
+	test(t, err == nil, "Expected check balance of the backer, got", err)
+	test(t, balance == 940, "Expected 940 points of the backer, got", balance)
+}
+
+func TestTournamentDelete(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	playerP1, err := player.New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP1.Fund(1000)
+	test(t, err == nil, "Expected fund 1000 to the player, got", err)
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(1000)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(playerP1)
+	test(t, err == nil, "Expected join a player, got", err)
+
+	err = Delete(1, store)
+	test(t, err == ErrOpenStakes, "Expected", ErrOpenStakes, "got", err)
+	err = Archive(1, store)
+	test(t, err == ErrOpenStakes, "Expected", ErrOpenStakes, "got", err)
+	err = tournament.Result(nil)
+	test(t, err == nil, "Expected result of the tournament, got", err)
+
+	err = Archive(1, store)
+	test(t, err == nil, "Expected archive the tournament, got", err)
+	_, err = Find(1, store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+	err = Restore(1, store)
+	test(t, err == nil, "Expected restore the tournament, got", err)
+	_, err = Find(1, store)
+	test(t, err == nil, "Expected find restored tournament, got", err)
+	err = Delete(1, store)
+	test(t, err == nil, "Expected delete the tournament, got", err)
+	_, err = Find(1, store)
+	test(t, err == datastore.ErrRecordNotFound, "Expected", datastore.ErrRecordNotFound, "got", err)
+}
+
+func TestTournamentRedis(t *testing.T) {
+
+	server := redistest.NewServer()
+	store := datastore.NewRedis("backer:", func() (datastore.RedisConn, error) {
+		return server.Conn(), nil
+	})
+	store.Reset()
+	playerP1, err := player.New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	playerB1, err := player.New("b1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP1.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	err = playerB1.Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(1000)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(playerP1, playerB1)
+	test(t, err == nil, "Expected join players, got", err)
+	err = tournament.Join(playerB1)
+	test(t, err == player.ErrInsufficientPoints, "Expected", player.ErrInsufficientPoints, "got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{playerP1: 3000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	balance, err := playerP1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1500, "Expected 1500 points for the player, got", balance)
+	balance, err = playerB1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1500, "Expected 1500 points for the player, got", balance)
+	found, err := Find(1, store)
+	test(t, err == nil, "Expected find the tournament, got", err)
+	test(t, found.IsFinished, "Expected finished tournament, got", found.Tournament)
+}
+
+func TestTournamentContext(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	playerP1, err := player.NewContext(ctx, "p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP1.FundContext(ctx, 1000)
+	test(t, err == nil, "Expected fund 1000 to the player, got", err)
+	tournament, err := NewContext(ctx, 1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.AnnounceContext(ctx, 500)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+
+	cancel()
+	err = tournament.JoinContext(ctx, playerP1)
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	balance, err := playerP1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1000, "Expected 1000 points for the player, got", balance)
+
+	ctx = context.Background()
+	err = tournament.JoinContext(ctx, playerP1)
+	test(t, err == nil, "Expected join a player, got", err)
+	ctx, cancel = context.WithCancel(ctx)
+	cancel()
+	err = tournament.ResultContext(ctx, map[backer.Player]backer.Points{playerP1: 1000})
+	test(t, err == context.Canceled, "Expected", context.Canceled, "got", err)
+	err = tournament.ResultContext(context.Background(), map[backer.Player]backer.Points{playerP1: 1000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	balance, err = playerP1.BalanceContext(context.Background())
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1500, "Expected 1500 points for the player, got", balance)
+}
+
+func TestTournamentLedger(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	for id, prize := range map[uint64]backer.Points{1: 500, 2: 3000} {
+		tournament, err := New(id, store)
+		test(t, err == nil, "Expected creating a new tournament, got", err)
+		err = tournament.Announce(500)
+		test(t, err == nil, "Expected announce of the tournament, got", err)
+		err = tournament.Join(players["p1"], players["b1"])
+		test(t, err == nil, "Expected join a player with backer, got", err)
+		err = tournament.Join(players["p2"])
+		test(t, err == nil, "Expected join a player, got", err)
+		err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: prize})
+		test(t, err == nil, "Expected result of the tournament, got", err)
+	}
+
+	fees, _, err := store.ListOperations(datastore.OperationQuery{Kind: ledger.Fee}, nil)
+	test(t, err == nil, "Expected list of operations, got", err)
+	test(t, len(fees) == 2, "Expected 2 fees, got", len(fees))
+	for _, fee := range fees {
+		expected := backer.Points(-500)
+		if fee.Tournament == 2 {
+			expected = 2000
+		}
+		test(t, fee.Postings[0].Account == ledger.Escrow(fee.Tournament) && fee.Postings[0].Amount == expected,
+			"Expected", expected, "points from the escrow, got", fee.Postings)
+	}
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+	test(t, report.Accounts[ledger.Player("p1")] == 2250, "Expected 2250 points of the player, got", report.Accounts)
+	test(t, report.Accounts[ledger.House] == -4500, "Expected -4500 points of the house, got", report.Accounts)
+}
+
+func TestTournamentIdempotency(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	playerP1, err := player.New("p1", store)
+	test(t, err == nil, "Expected creating a new player, got", err)
+	err = playerP1.Fund(1000)
+	test(t, err == nil, "Expected fund 1000 to the player, got", err)
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(500)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+
+	ctx := datastore.WithIdempotencyKey(context.Background(), "join-1")
+	for idx := 0; idx < 2; idx++ {
+		err = tournament.JoinContext(ctx, playerP1)
+		test(t, err == nil, "Expected join a player, got", err)
+	}
+	test(t, len(tournament.Bidders) == 1, "Expected 1 bidder, got", len(tournament.Bidders))
+	err = tournament.Join(playerP1)
+	test(t, err == ErrCouldNotJoinTwice, "Expected", ErrCouldNotJoinTwice, "got", err)
+
+	ctx = datastore.WithIdempotencyKey(context.Background(), "result-1")
+	for idx := 0; idx < 2; idx++ {
+		err = tournament.ResultContext(ctx, map[backer.Player]backer.Points{playerP1: 700})
+		test(t, err == nil, "Expected result of the tournament, got", err)
+	}
+	test(t, tournament.IsFinished, "Expected finished tournament")
+	err = tournament.ResultContext(ctx, map[backer.Player]backer.Points{playerP1: 900})
+	test(t, err == datastore.ErrIdempotencyKeyReused, "Expected", datastore.ErrIdempotencyKeyReused, "got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{playerP1: 700})
+	test(t, err == ErrAllreadyFinished, "Expected", ErrAllreadyFinished, "got", err)
+	balance, err := playerP1.Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1200, "Expected 1200 points for the player, got", balance)
+}
+
+func TestTournamentReverseResult(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(1000)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+	err = tournament.ReverseResult("admin", "wrong winner", ledger.FailInsufficient)
+	test(t, err == ErrNotFinished, "Expected", ErrNotFinished, "got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 1500})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	err = players["b1"].Take(1000)
+	test(t, err == nil, "Expected take 1000 from the player, got", err)
+
+	err = tournament.ReverseResult("admin", "wrong winner", ledger.FailInsufficient)
+	test(t, err == ledger.ErrInsufficientPoints, "Expected", ledger.ErrInsufficientPoints, "got", err)
+	test(t, tournament.IsFinished, "Expected finished tournament")
+	err = tournament.ReverseResult("admin", "wrong winner", ledger.PartialClawback)
+	test(t, err == nil, "Expected reversal of the result, got", err)
+	test(t, !tournament.IsFinished && !tournament.Bidders[0].Winner,
+		"Expected open tournament without winners, got", tournament.Tournament)
+	for id, expected := range map[string]backer.Points{"p1": 500, "b1": 0, "p2": 0} {
+		balance, err := players[id].Balance()
+		test(t, err == nil, "Expected check balance of the player, got", err)
+		test(t, balance == expected, "Expected", expected, "points for the player", id, "got", balance)
+	}
+
+	err = tournament.Result(map[backer.Player]backer.Points{players["p2"]: 2000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	balance, err := players["p2"].Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 2000, "Expected 2000 points for the player, got", balance)
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+}
+
+func TestTournamentCurrency(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1", "p3"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		if id != "p3" {
+			err = entry.FundIn("tickets", 300)
+			test(t, err == nil, "Expected fund 300 tickets to the player, got", err)
+		}
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.AnnounceIn("tickets", 200)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	test(t, tournament.Tournament.Currency == "tickets", "Expected tickets deposit, got", tournament.Tournament.Currency)
+	err = tournament.JoinIn("", players["p1"], players["b1"])
+	test(t, err == ErrWrongCurrency, "Expected", ErrWrongCurrency, "got", err)
+	err = tournament.Join(players["p3"])
+	test(t, err == ledger.ErrInsufficientPoints, "Expected", ledger.ErrInsufficientPoints, "got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = tournament.JoinIn("tickets", players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 600})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+
+	balance, err := players["p1"].Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1000, "Expected 1000 points for the player, got", balance)
+	tickets, err := players["p1"].Wallet("tickets")
+	test(t, err == nil, "Expected check wallet of the player, got", err)
+	test(t, tickets == 500, "Expected 500 tickets for the player, got", tickets)
+	tickets, err = players["p2"].Wallet("tickets")
+	test(t, err == nil, "Expected check wallet of the player, got", err)
+	test(t, tickets == 100, "Expected 100 tickets for the player, got", tickets)
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+	test(t, report.Accounts[ledger.Wallet(ledger.House, "tickets")] == -1100,
+		"Expected -1100 tickets of the house, got", report.Accounts)
+	test(t, report.Accounts[ledger.House] == -4000, "Expected -4000 points of the house, got", report.Accounts)
+}
+
+func TestTournamentSuspended(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(0)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = players["b1"].Suspend("admin", "investigation", false)
+	test(t, err == nil, "Expected suspension of the player, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == ledger.ErrAccountSuspended, "Expected", ledger.ErrAccountSuspended, "got", err)
+	err = players["b1"].Close("admin", "fraud")
+	test(t, err == player.ErrNonZeroBalance, "Expected", player.ErrNonZeroBalance, "got", err)
+	err = players["b1"].Activate("admin", "cleared")
+	test(t, err == nil, "Expected activation of the player, got", err)
+	err = players["b1"].Take(1000)
+	test(t, err == nil, "Expected take 1000 from the player, got", err)
+	err = players["b1"].Close("admin", "fraud")
+	test(t, err == nil, "Expected closing of the player, got", err)
+	err = tournament.Join(players["b1"])
+	test(t, err == ledger.ErrAccountClosed, "Expected", ledger.ErrAccountClosed, "got", err)
+	err = tournament.Join(players["p1"])
+	test(t, err == nil, "Expected join a player, got", err)
+	test(t, len(tournament.Tournament.Bidders) == 1, "Expected 1 bidder, got", tournament.Tournament.Bidders)
+}
+
+func TestTournamentWithheld(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1", "b2"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(300)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b1"], players["b2"])
+	test(t, err == nil, "Expected join a player with backers, got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+
+	err = players["b2"].Take(900)
+	test(t, err == nil, "Expected take 900 from the player, got", err)
+	err = players["b2"].Close("admin", "requested")
+	test(t, err == player.ErrOpenStakes, "Expected", player.ErrOpenStakes, "got", err)
+	err = players["b1"].Suspend("admin", "fraud", true)
+	test(t, err == nil, "Expected suspension of the player, got", err)
+	// the account closed before the checks were introduced
+	err = datastore.WithTransaction(store, func(tx datastore.Transact) error {
+		closed, err := store.FindPlayerForUpdate("b2", tx)
+		if err != nil {
+			return err
+		}
+		closed.Status = model.Closed
+		return store.SavePlayer(closed, tx)
+	})
+	test(t, err == nil, "Expected closing of the player, got", err)
+
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 600})
+	test(t, err == nil, "Expected result of the tournament with closed and frozen backers, got", err)
+	bidders := tournament.Tournament.Bidders
+	test(t, len(bidders[0].Withheld) == 2 && bidders[0].Withheld[0] == "b1" && bidders[0].Withheld[1] == "b2",
+		"Expected withheld shares of the backers, got", bidders[0].Withheld)
+	balance, err := players["p1"].Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 1100, "Expected 1100 points for the player, got", balance)
+	balance, err = players["b1"].Balance()
+	test(t, err == nil, "Expected check balance of the player, got", err)
+	test(t, balance == 900, "Expected 900 points for the frozen backer, got", balance)
+	report, err := ledger.Reconcile(store, nil)
+	test(t, err == nil, "Expected reconciliation, got", err)
+	test(t, report.Reconciled(), "Expected reconciled ledger, got", report)
+	test(t, report.Accounts[ledger.House] == -2700, "Expected -2700 points of the house, got", report.Accounts)
+}
+
+func TestTournamentLimits(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	defer func() { limits.Defaults = model.Limits{} }()
+	limits.Defaults = model.Limits{Spend: model.Periods{Daily: 400}, Stake: model.Periods{Daily: 200}}
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	for id := uint64(1); id <= 2; id++ {
+		tournament, err := New(id, store)
+		test(t, err == nil, "Expected creating a new tournament, got", err)
+		err = tournament.Announce(300)
+		test(t, err == nil, "Expected announce of the tournament, got", err)
+		err = tournament.Join(players["p1"], players["b1"])
+		if id == 1 {
+			test(t, err == nil, "Expected join a player with backer, got", err)
+			continue
+		}
+		limit, ok := err.(*limits.Error)
+		test(t, ok && limit.Category == limits.Stake && limit.Period == limits.Daily,
+			"Expected daily stake limit of the backer, got", err)
+		err = tournament.Join(players["p1"])
+		limit, ok = err.(*limits.Error)
+		test(t, ok && limit.Category == limits.Spend && limit.Used == 150,
+			"Expected daily spend limit of the player, got", err)
+		err = tournament.Join(players["p2"])
+		test(t, err == nil, "Expected join a player, got", err)
+	}
+}
+
+func TestTournamentExclusion(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	now := time.Now()
+	err := players["b1"].Exclude(now, now.Add(time.Hour))
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	err = players["p2"].Exclude(now.Add(time.Hour), now.Add(2*time.Hour))
+	test(t, err == nil, "Expected exclusion of the player, got", err)
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(300)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == player.ErrSelfExcluded, "Expected", player.ErrSelfExcluded, "got", err)
+	err = tournament.Join(players["b1"])
+	test(t, err == player.ErrSelfExcluded, "Expected", player.ErrSelfExcluded, "got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player before the exclusion, got", err)
+	defer func() { player.Admin = nil }()
+	player.Admin = func(ctx context.Context, actor string) bool { return actor == "admin" }
+	err = players["b1"].LiftExclusion("admin", "mistake")
+	test(t, err == nil, "Expected lifting of the exclusion, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+}
+
+func TestTournamentCredit(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		players[id] = entry
+	}
+	err := players["p2"].Fund(500)
+	test(t, err == nil, "Expected fund 500 to the player, got", err)
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(500)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"])
+	test(t, err == ledger.ErrInsufficientPoints, "Expected", ledger.ErrInsufficientPoints, "got", err)
+	err = players["p1"].SetCreditLimit("admin", 500)
+	test(t, err == nil, "Expected setting credit limit of the player, got", err)
+	err = tournament.Join(players["p1"])
+	test(t, err == nil, "Expected join a player on credit, got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+	funds, err := players["p1"].Funds()
+	test(t, err == nil, "Expected funds of the player, got", err)
+	test(t, funds == player.Funds{Balance: -500, Available: 0, Credit: 500, Outstanding: 500},
+		"Expected 500 points of outstanding credit, got", funds)
+
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 800})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	funds, err = players["p1"].Funds()
+	test(t, err == nil, "Expected funds of the player, got", err)
+	test(t, funds == player.Funds{Balance: 300, Available: 800, Credit: 500},
+		"Expected repaid credit from the prize, got", funds)
+	_, total, err := ledger.Credits(store, nil)
+	test(t, err == nil, "Expected credits of the players, got", err)
+	test(t, total == 0, "Expected no outstanding credit, got", total)
+}
+
+func TestTournamentRules(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1", "b2"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	tournament.Rules = &Rules{SingleBidder: true}
+	tournament.Rules.Block("b2", "p1")
+	err = tournament.Announce(300)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["p1"])
+	test(t, err == ErrSelfBacking, "Expected", ErrSelfBacking, "got", err)
+	err = tournament.Join(players["p1"], players["b1"], players["b1"])
+	test(t, err == ErrDuplicateBacker, "Expected", ErrDuplicateBacker, "got", err)
+
+	tournament, err = Find(1, store)
+	test(t, err == nil, "Expected reloading of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b2"])
+	test(t, err == ErrBlockedBacker, "Expected", ErrBlockedBacker, "got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = tournament.Join(players["p2"], players["b1"])
+	test(t, err == ErrBackingOpponents, "Expected", ErrBackingOpponents, "got", err)
+	err = tournament.Join(players["p2"], players["p1"])
+	test(t, err == ErrBackingOpponents, "Expected", ErrBackingOpponents, "got", err)
+	err = tournament.Join(players["b1"])
+	test(t, err == ErrBackingOpponents, "Expected", ErrBackingOpponents, "got", err)
+	err = tournament.Join(players["p2"], players["b2"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+
+	other, err := New(2, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = other.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = other.Join(players["p2"], players["b1"])
+	test(t, err == nil, "Expected backing of opponents by default, got", err)
+
+	defaults := DefaultRules
+	defer func() { DefaultRules = defaults }()
+	DefaultRules = Rules{SingleBidder: true}
+	third, err := New(3, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = third.Announce(300)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	DefaultRules = defaults
+	third, err = Find(3, store)
+	test(t, err == nil, "Expected reloading of the tournament, got", err)
+	err = third.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = third.Join(players["p2"], players["b1"])
+	test(t, err == ErrBackingOpponents, "Expected default rules kept with the tournament, got", err)
+}
+
+func TestTournamentStats(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(500)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 1000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+
+	report, err := players["b1"].Stats()
+	test(t, err == nil, "Expected statistics of the player, got", err)
+	test(t, report.Backer.Entries == 1 && report.Backer.Staked == 250 && report.Backer.Returned == 500 &&
+		report.Backer.ROI == 1 && report.Backer.BiggestWin == 500, "Expected backer statistics, got", report.Backer)
+	report, err = players["p2"].Stats()
+	test(t, err == nil, "Expected statistics of the player, got", err)
+	test(t, report.Bidder.Entries == 1 && report.Bidder.Profit == -500 && report.Bidder.ROI == -1,
+		"Expected bidder statistics, got", report.Bidder)
+
+	err = tournament.ReverseResult("admin", "wrong winner", ledger.FailInsufficient)
+	test(t, err == nil, "Expected reversal of the result, got", err)
+	report, err = players["b1"].Stats()
+	test(t, err == nil, "Expected statistics of the player, got", err)
+	test(t, report.Backer.Entries == 0 && report.Backer.Returned == 0, "Expected reverted statistics, got", report.Backer)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p2"]: 1000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	report, err = players["p2"].Stats()
+	test(t, err == nil, "Expected statistics of the player, got", err)
+	test(t, report.Bidder.Entries == 1 && report.Bidder.Profit == 500 && report.Bidder.CashRate == 1,
+		"Expected bidder statistics, got", report.Bidder)
+}
+
+func TestTournamentLeaderboard(t *testing.T) {
+
+	store := new(datastore.Stub)
+	store.Reset()
+	players := make(map[string]*player.Entry)
+	for _, id := range []string{"p1", "p2", "b1"} {
+		entry, err := player.New(id, store)
+		test(t, err == nil, "Expected creating a new player, got", err)
+		err = entry.Fund(1000)
+		test(t, err == nil, "Expected fund 1000 to the player, got", err)
+		players[id] = entry
+	}
+	tournament, err := New(1, store)
+	test(t, err == nil, "Expected creating a new tournament, got", err)
+	err = tournament.Announce(500)
+	test(t, err == nil, "Expected announce of the tournament, got", err)
+	err = tournament.Join(players["p1"], players["b1"])
+	test(t, err == nil, "Expected join a player with backer, got", err)
+	err = tournament.Join(players["p2"])
+	test(t, err == nil, "Expected join a player, got", err)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p1"]: 1000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+
+	top, err := leaderboard.Top(store, leaderboard.Winnings, leaderboard.Weekly, time.Now(), 10)
+	test(t, err == nil, "Expected winnings leaderboard, got", err)
+	test(t, len(top) == 3 && top[0].Value == 500 && top[1].Value == 500 && top[2].Player == "p2",
+		"Expected p1 and b1 on the top and p2 the last, got", top)
+	standing, err := leaderboard.Rank(store, leaderboard.BackerProfit, leaderboard.AllTime, time.Now(), "b1")
+	test(t, err == nil && standing.Rank == 1 && standing.Value == 250,
+		"Expected b1 backers' profit 250, got", standing, err)
+
+	err = tournament.ReverseResult("admin", "wrong winner", ledger.FailInsufficient)
+	test(t, err == nil, "Expected reversal of the result, got", err)
+	top, err = leaderboard.Top(store, leaderboard.Winnings, leaderboard.Weekly, time.Now(), 10)
+	test(t, err == nil && len(top) == 0, "Expected empty leaderboard after reversal, got", top, err)
+	err = tournament.Result(map[backer.Player]backer.Points{players["p2"]: 1000})
+	test(t, err == nil, "Expected result of the tournament, got", err)
+	top, err = leaderboard.Top(store, leaderboard.Wins, leaderboard.Monthly, time.Now(), 1)
+	test(t, err == nil && len(top) == 1 && top[0].Player == "p2" && top[0].Value == 1,
+		"Expected p2 on the top of wins, got", top, err)
+}